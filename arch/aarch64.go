@@ -0,0 +1,106 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+type ArchAArch64 struct {}
+
+func (s *ArchAArch64) GetRegStack() int {return uc.ARM64_REG_SP}
+func (s *ArchAArch64) GetRegIP() int {return uc.ARM64_REG_PC}
+func (s *ArchAArch64) GetRegStackBase() int {return uc.ARM64_REG_FP}
+func (s *ArchAArch64) GetRegLink() int {return uc.ARM64_REG_LR}
+func (s *ArchAArch64) GetPointerSize() int {return 8}
+func (s *ArchAArch64) GetByteOrder() binary.ByteOrder {return binary.LittleEndian}
+func (s *ArchAArch64) GetABI() *ABI {return &abi_aapcs64}
+func (s *ArchAArch64) ToUnicornArchDescription() int {return uc.ARCH_ARM64}
+func (s *ArchAArch64) ToUnicornModeDescription() int {return uc.MODE_ARM}
+func (s *ArchAArch64) ToCapstoneArchDescription() int {return gapstone.CS_ARCH_ARM64}
+func (s *ArchAArch64) ToCapstoneModeDescription() int {return gapstone.CS_MODE_ARM}
+
+//DWARF for the ARM 64-bit Architecture, 3.1: x0-x30 are 0-30, sp is 31
+func (s *ArchAArch64) GetDwarfRegister(num uint64) (int, bool) {
+	if num > 31 {
+		return 0, false
+	}
+	if num == 31 {
+		return uc.ARM64_REG_SP, true
+	}
+	return regs_by_index_aarch64[num], true
+}
+
+//ret xN and eret return, the conditional branches are b.cond, cbz, cbnz, tbz and tbnz
+func (s *ArchAArch64) Classify(ins *gapstone.Instruction) InstructionClass {
+	switch ins.Id {
+	case gapstone.ARM64_INS_RET, gapstone.ARM64_INS_ERET:
+		return ClassReturn
+	case gapstone.ARM64_INS_BL, gapstone.ARM64_INS_BLR:
+		return ClassCall
+	case gapstone.ARM64_INS_BR:
+		return ClassIndirect
+	case gapstone.ARM64_INS_B:
+		if ins.Arm64.CC == gapstone.ARM64_CC_INVALID || ins.Arm64.CC == gapstone.ARM64_CC_AL {
+			return ClassJump
+		}
+		return ClassConditional
+	case gapstone.ARM64_INS_CBZ, gapstone.ARM64_INS_CBNZ, gapstone.ARM64_INS_TBZ, gapstone.ARM64_INS_TBNZ:
+		return ClassConditional
+	case gapstone.ARM64_INS_SVC:
+		return ClassSyscall
+	case gapstone.ARM64_INS_BRK, gapstone.ARM64_INS_HLT:
+		return ClassTrap
+	}
+	return ClassNone
+}
+
+var regs_by_index_aarch64 = []int{
+	uc.ARM64_REG_X0,
+	uc.ARM64_REG_X1,
+	uc.ARM64_REG_X2,
+	uc.ARM64_REG_X3,
+	uc.ARM64_REG_X4,
+	uc.ARM64_REG_X5,
+	uc.ARM64_REG_X6,
+	uc.ARM64_REG_X7,
+	uc.ARM64_REG_X8,
+	uc.ARM64_REG_X9,
+	uc.ARM64_REG_X10,
+	uc.ARM64_REG_X11,
+	uc.ARM64_REG_X12,
+	uc.ARM64_REG_X13,
+	uc.ARM64_REG_X14,
+	uc.ARM64_REG_X15,
+	uc.ARM64_REG_X16,
+	uc.ARM64_REG_X17,
+	uc.ARM64_REG_X18,
+	uc.ARM64_REG_X19,
+	uc.ARM64_REG_X20,
+	uc.ARM64_REG_X21,
+	uc.ARM64_REG_X22,
+	uc.ARM64_REG_X23,
+	uc.ARM64_REG_X24,
+	uc.ARM64_REG_X25,
+	uc.ARM64_REG_X26,
+	uc.ARM64_REG_X27,
+	uc.ARM64_REG_X28,
+	uc.ARM64_REG_FP,
+	uc.ARM64_REG_LR,
+	uc.ARM64_REG_SP,
+	uc.ARM64_REG_PC,
+	uc.ARM64_REG_NZCV,
+}
+
+//Procedure Call Standard for the ARM 64-bit Architecture, 6.1. x18 is left to the platform.
+var abi_aapcs64 = ABI{
+	Arguments: []int{uc.ARM64_REG_X0, uc.ARM64_REG_X1, uc.ARM64_REG_X2, uc.ARM64_REG_X3, uc.ARM64_REG_X4, uc.ARM64_REG_X5,
+		uc.ARM64_REG_X6, uc.ARM64_REG_X7},
+	Ret: uc.ARM64_REG_X0,
+	CalleeSaved: []int{uc.ARM64_REG_X19, uc.ARM64_REG_X20, uc.ARM64_REG_X21, uc.ARM64_REG_X22, uc.ARM64_REG_X23,
+		uc.ARM64_REG_X24, uc.ARM64_REG_X25, uc.ARM64_REG_X26, uc.ARM64_REG_X27, uc.ARM64_REG_X28, uc.ARM64_REG_FP},
+	Scratch: []int{uc.ARM64_REG_X8, uc.ARM64_REG_X9, uc.ARM64_REG_X10, uc.ARM64_REG_X11, uc.ARM64_REG_X12,
+		uc.ARM64_REG_X13, uc.ARM64_REG_X14, uc.ARM64_REG_X15, uc.ARM64_REG_X16, uc.ARM64_REG_X17, uc.ARM64_REG_X18,
+		uc.ARM64_REG_LR},
+	StackAlign: 16,
+}
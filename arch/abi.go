@@ -0,0 +1,32 @@
+package arch
+
+// ABI describes the calling convention functions are emulated with: where the arguments are passed, where the result is
+// returned and what the stack looks like on entry. Only the integer registers are covered.
+type ABI struct {
+	Arguments         []int  //in the order of the arguments
+	Ret               int    //return value
+	CalleeSaved       []int  //preserved across calls, besides the stack pointer
+	Scratch           []int  //the remaining registers a call may clobber, including the link register
+	StackAlign        uint64 //of the stack pointer at the call
+	ReturnAddressSize uint64 //pushed by the call, 0 if the return address is passed in a register
+	HomeSpace         uint64 //reserved by the caller above the return address, for the register arguments
+	RedZone           uint64 //below the stack pointer, used by leaf functions without moving it
+	Entry             int    //holds the address of the function on entry, 0 for none
+	GlobalPointer     int    //set up once by the runtime to address global data, not seeded from the Environment, 0 for none
+}
+
+// Registers returns the general purpose registers but the stack pointer, in the order they are seeded from the
+// Environment. The arguments come first, so that the n-th argument starts with the same value in every convention.
+func (a *ABI) Registers() []int {
+	res := make([]int, 0, len(a.Arguments)+len(a.Scratch)+len(a.CalleeSaved)+1)
+	seen := make(map[int]bool)
+	for _, group := range [][]int{a.Arguments, {a.Ret}, a.Scratch, a.CalleeSaved} {
+		for _, reg := range group {
+			if !seen[reg] {
+				seen[reg] = true
+				res = append(res, reg)
+			}
+		}
+	}
+	return res
+}
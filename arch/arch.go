@@ -1,12 +1,21 @@
 package arch
 
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+)
+
 type Arch interface {
-  GetRegisters() []int
-  IsRet(mem []byte) bool
+  Classify(ins *gapstone.Instruction) InstructionClass //ins has to be decoded with details (CS_OPT_DETAIL)
   GetRegIP() int
   GetRegStack() int
   GetRegStackBase() int
-  GetRegRet() int
+  GetABI() *ABI //calling convention, drives the seeding of registers and the return value
+  GetPointerSize() int
+  GetByteOrder() binary.ByteOrder //of instructions and data in memory
+  GetDwarfRegister(num uint64) (int, bool) //unicorn register for a DWARF register number (as used by .eh_frame)
   ToUnicornArchDescription() int //X86? ARM? PPC?
   ToUnicornModeDescription() int //32 or 64 byte
+  ToCapstoneArchDescription() int
+  ToCapstoneModeDescription() int
 }
@@ -0,0 +1,162 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//32 bit ARM, Thumb selects the instruction set a function is decoded and emulated in. ARM binaries mix both, the lowest
+//bit of symbol values and branch targets is set for Thumb code.
+type ArchARM struct {
+	Thumb bool
+}
+
+func (s *ArchARM) GetRegStack() int {return uc.ARM_REG_SP}
+func (s *ArchARM) GetRegIP() int {return uc.ARM_REG_PC}
+func (s *ArchARM) GetRegLink() int {return uc.ARM_REG_LR}
+func (s *ArchARM) GetPointerSize() int {return 4}
+func (s *ArchARM) GetByteOrder() binary.ByteOrder {return binary.LittleEndian}
+func (s *ArchARM) GetABI() *ABI {return &abi_aapcs}
+func (s *ArchARM) ToUnicornArchDescription() int {return uc.ARCH_ARM}
+func (s *ArchARM) ToCapstoneArchDescription() int {return gapstone.CS_ARCH_ARM}
+
+//gcc uses r7 as frame pointer in Thumb code and r11 in ARM code
+func (s *ArchARM) GetRegStackBase() int {
+	if s.Thumb {
+		return uc.ARM_REG_R7
+	}
+	return uc.ARM_REG_R11
+}
+
+func (s *ArchARM) ToUnicornModeDescription() int {
+	if s.Thumb {
+		return uc.MODE_THUMB
+	}
+	return uc.MODE_ARM
+}
+
+func (s *ArchARM) ToCapstoneModeDescription() int {
+	if s.Thumb {
+		return gapstone.CS_MODE_THUMB
+	}
+	return gapstone.CS_MODE_ARM
+}
+
+//Unicorn starts in Thumb state if the lowest bit of the start address is set
+func (s *ArchARM) StartAddress(addr uint64) uint64 {
+	if s.Thumb {
+		return addr | 1
+	}
+	return addr
+}
+
+//DWARF for the ARM Architecture, 3.1: r0-r15 are 0-15
+func (s *ArchARM) GetDwarfRegister(num uint64) (int, bool) {
+	if num > 15 {
+		return 0, false
+	}
+	return dwarf_regs_arm[num], true
+}
+
+//Every instruction may be executed conditionally, Classify only sees the condition field of ARM code and of b in
+//Thumb code. The conditions an IT instruction imposes on the Thumb instructions following it are not known here.
+func (s *ArchARM) Classify(ins *gapstone.Instruction) InstructionClass {
+	switch ins.Id {
+	case gapstone.ARM_INS_UDF, gapstone.ARM_INS_BKPT:
+		return ClassTrap
+	case gapstone.ARM_INS_SVC:
+		return ClassSyscall
+	case gapstone.ARM_INS_BL, gapstone.ARM_INS_BLX:
+		return ClassCall
+	case gapstone.ARM_INS_CBZ, gapstone.ARM_INS_CBNZ:
+		return ClassConditional
+	case gapstone.ARM_INS_B:
+		if ins.Arm.CC != gapstone.ARM_CC_INVALID && ins.Arm.CC != gapstone.ARM_CC_AL {
+			return ClassConditional
+		}
+		return ClassJump
+	case gapstone.ARM_INS_BX, gapstone.ARM_INS_BXJ, gapstone.ARM_INS_TBB, gapstone.ARM_INS_TBH:
+		if is_arm_return(ins) {
+			return ClassReturn
+		}
+		return ClassIndirect
+	}
+	switch {
+	case is_arm_return(ins):
+		return ClassReturn
+	case writes_pc(ins):
+		return ClassIndirect
+	}
+	return ClassNone
+}
+
+func arm_reg(op gapstone.ArmOperand, reg uint) bool {
+	return op.Type == gapstone.ARM_OP_REG && op.Reg == reg
+}
+
+func loads_pc(ins *gapstone.Instruction) bool {
+	for _, op := range ins.Arm.Operands {
+		if arm_reg(op, gapstone.ARM_REG_PC) {
+			return true
+		}
+	}
+	return false
+}
+
+//writes_pc is true for the instructions that branch by writing the pc like any other register
+func writes_pc(ins *gapstone.Instruction) bool {
+	ops := ins.Arm.Operands
+	switch ins.Id {
+	case gapstone.ARM_INS_POP, gapstone.ARM_INS_LDM, gapstone.ARM_INS_LDMDB:
+		return loads_pc(ins)
+	case gapstone.ARM_INS_LDR, gapstone.ARM_INS_MOV, gapstone.ARM_INS_ADD, gapstone.ARM_INS_SUB:
+		return len(ops) > 0 && arm_reg(ops[0], gapstone.ARM_REG_PC)
+	}
+	return false
+}
+
+//is_arm_return matches bx lr, mov pc, lr, pop {..., pc} (also as ldm sp!) and ldr pc, [...]
+func is_arm_return(ins *gapstone.Instruction) bool {
+	ops := ins.Arm.Operands
+	switch ins.Id {
+	case gapstone.ARM_INS_BX:
+		return len(ops) == 1 && arm_reg(ops[0], gapstone.ARM_REG_LR)
+	case gapstone.ARM_INS_MOV:
+		return len(ops) == 2 && arm_reg(ops[0], gapstone.ARM_REG_PC) && arm_reg(ops[1], gapstone.ARM_REG_LR)
+	case gapstone.ARM_INS_POP, gapstone.ARM_INS_LDR:
+		return writes_pc(ins)
+	case gapstone.ARM_INS_LDM:
+		return len(ops) > 0 && arm_reg(ops[0], gapstone.ARM_REG_SP) && writes_pc(ins)
+	}
+	return false
+}
+
+var dwarf_regs_arm = []int{
+	uc.ARM_REG_R0,
+	uc.ARM_REG_R1,
+	uc.ARM_REG_R2,
+	uc.ARM_REG_R3,
+	uc.ARM_REG_R4,
+	uc.ARM_REG_R5,
+	uc.ARM_REG_R6,
+	uc.ARM_REG_R7,
+	uc.ARM_REG_R8,
+	uc.ARM_REG_R9,
+	uc.ARM_REG_R10,
+	uc.ARM_REG_R11,
+	uc.ARM_REG_R12,
+	uc.ARM_REG_SP,
+	uc.ARM_REG_LR,
+	uc.ARM_REG_PC,
+}
+
+//Procedure Call Standard for the ARM Architecture, 5.1.1
+var abi_aapcs = ABI{
+	Arguments: []int{uc.ARM_REG_R0, uc.ARM_REG_R1, uc.ARM_REG_R2, uc.ARM_REG_R3},
+	Ret:       uc.ARM_REG_R0,
+	CalleeSaved: []int{uc.ARM_REG_R4, uc.ARM_REG_R5, uc.ARM_REG_R6, uc.ARM_REG_R7, uc.ARM_REG_R8, uc.ARM_REG_R9,
+		uc.ARM_REG_R10, uc.ARM_REG_R11},
+	Scratch:    []int{uc.ARM_REG_R12, uc.ARM_REG_LR},
+	StackAlign: 8,
+}
@@ -0,0 +1,42 @@
+package arch
+
+// InstructionClass tells how an instruction affects the control flow, see Arch.Classify. Block discovery and the
+// emulator both classify through the Arch, so that they agree on what a return is.
+type InstructionClass int
+
+const (
+	ClassNone        InstructionClass = iota //control continues with the next instruction
+	ClassReturn                              //to the address the call left on the stack or in the link register
+	ClassCall                                //direct or through a register or memory, control comes back behind it
+	ClassJump                                //unconditional, to a target encoded in the instruction
+	ClassConditional                         //to a target encoded in the instruction or to the next instruction
+	ClassIndirect                            //jump through a register or memory (jump tables, tail calls)
+	ClassSyscall                             //enters the kernel, control continues behind it
+	ClassTrap                                //raises an exception, control does not continue (hlt, ud2, brk)
+)
+
+// IsTransfer is true for the classes that end a basic block. Syscalls are not among them, control continues behind
+// them like behind any other instruction.
+func (c InstructionClass) IsTransfer() bool {
+	return c != ClassNone && c != ClassSyscall
+}
+
+func (c InstructionClass) String() string {
+	switch c {
+	case ClassReturn:
+		return "return"
+	case ClassCall:
+		return "call"
+	case ClassJump:
+		return "jump"
+	case ClassConditional:
+		return "conditional"
+	case ClassIndirect:
+		return "indirect"
+	case ClassSyscall:
+		return "syscall"
+	case ClassTrap:
+		return "trap"
+	}
+	return "none"
+}
@@ -0,0 +1,139 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//32 bit MIPS, big endian unless LittleEndian is set (mipsel). Every jump and branch is followed by a delay slot, the
+//instruction in it is executed before control leaves.
+type ArchMIPS struct {
+	LittleEndian bool
+}
+
+func (s *ArchMIPS) GetRegStack() int {return uc.MIPS_REG_SP}
+func (s *ArchMIPS) GetRegIP() int {return uc.MIPS_REG_PC}
+func (s *ArchMIPS) GetRegStackBase() int {return uc.MIPS_REG_FP}
+func (s *ArchMIPS) GetRegLink() int {return uc.MIPS_REG_RA}
+func (s *ArchMIPS) GetPointerSize() int {return 4}
+func (s *ArchMIPS) GetABI() *ABI {return &abi_o32}
+func (s *ArchMIPS) ToUnicornArchDescription() int {return uc.ARCH_MIPS}
+func (s *ArchMIPS) ToCapstoneArchDescription() int {return gapstone.CS_ARCH_MIPS}
+
+func (s *ArchMIPS) GetByteOrder() binary.ByteOrder {
+	if s.LittleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func (s *ArchMIPS) ToUnicornModeDescription() int {
+	if s.LittleEndian {
+		return uc.MODE_MIPS32
+	}
+	return uc.MODE_MIPS32 | uc.MODE_BIG_ENDIAN
+}
+
+func (s *ArchMIPS) ToCapstoneModeDescription() int {
+	if s.LittleEndian {
+		return gapstone.CS_MODE_MIPS32
+	}
+	return gapstone.CS_MODE_MIPS32 | gapstone.CS_MODE_BIG_ENDIAN
+}
+
+//the general purpose registers are 0-31
+func (s *ArchMIPS) GetDwarfRegister(num uint64) (int, bool) {
+	if num > 31 {
+		return 0, false
+	}
+	if num == 0 {
+		return uc.MIPS_REG_ZERO, true
+	}
+	return regs_by_index_mips[num-1], true
+}
+
+//jr $ra and jalr $zero, $ra return. Jumps and branches take effect after their delay slot.
+func (s *ArchMIPS) Classify(ins *gapstone.Instruction) InstructionClass {
+	ops := ins.Mips.Operands
+	switch ins.Id {
+	case gapstone.MIPS_INS_BREAK:
+		return ClassTrap
+	case gapstone.MIPS_INS_SYSCALL:
+		return ClassSyscall
+	case gapstone.MIPS_INS_JALR:
+		if len(ops) != 2 || !mips_reg(ops[0], gapstone.MIPS_REG_ZERO) {
+			return ClassCall
+		}
+		ops = ops[1:] //links to $zero, a plain jr
+		fallthrough
+	case gapstone.MIPS_INS_JR:
+		if len(ops) == 1 && mips_reg(ops[0], gapstone.MIPS_REG_RA) {
+			return ClassReturn
+		}
+		return ClassIndirect //jump tables and tail calls through $t9
+	case gapstone.MIPS_INS_JAL, gapstone.MIPS_INS_JALX, gapstone.MIPS_INS_BAL, gapstone.MIPS_INS_BGEZAL,
+		gapstone.MIPS_INS_BLTZAL:
+		return ClassCall
+	case gapstone.MIPS_INS_J, gapstone.MIPS_INS_B:
+		return ClassJump
+	case gapstone.MIPS_INS_BEQ, gapstone.MIPS_INS_BNE, gapstone.MIPS_INS_BEQZ, gapstone.MIPS_INS_BNEZ,
+		gapstone.MIPS_INS_BGEZ, gapstone.MIPS_INS_BGTZ, gapstone.MIPS_INS_BLEZ, gapstone.MIPS_INS_BLTZ,
+		gapstone.MIPS_INS_BEQL, gapstone.MIPS_INS_BNEL, gapstone.MIPS_INS_BLEZL, gapstone.MIPS_INS_BGTZL,
+		gapstone.MIPS_INS_BLTZL, gapstone.MIPS_INS_BGEZL, gapstone.MIPS_INS_BC1F, gapstone.MIPS_INS_BC1T:
+		return ClassConditional
+	}
+	return ClassNone
+}
+
+func mips_reg(op gapstone.MipsOperand, reg uint) bool {
+	return op.Type == gapstone.MIPS_OP_REG && op.Reg == reg
+}
+
+//$zero is left out, it can't be written. In the order of the register numbers.
+var regs_by_index_mips = []int{
+	uc.MIPS_REG_AT,
+	uc.MIPS_REG_V0,
+	uc.MIPS_REG_V1,
+	uc.MIPS_REG_A0,
+	uc.MIPS_REG_A1,
+	uc.MIPS_REG_A2,
+	uc.MIPS_REG_A3,
+	uc.MIPS_REG_T0,
+	uc.MIPS_REG_T1,
+	uc.MIPS_REG_T2,
+	uc.MIPS_REG_T3,
+	uc.MIPS_REG_T4,
+	uc.MIPS_REG_T5,
+	uc.MIPS_REG_T6,
+	uc.MIPS_REG_T7,
+	uc.MIPS_REG_S0,
+	uc.MIPS_REG_S1,
+	uc.MIPS_REG_S2,
+	uc.MIPS_REG_S3,
+	uc.MIPS_REG_S4,
+	uc.MIPS_REG_S5,
+	uc.MIPS_REG_S6,
+	uc.MIPS_REG_S7,
+	uc.MIPS_REG_T8,
+	uc.MIPS_REG_T9,
+	uc.MIPS_REG_K0,
+	uc.MIPS_REG_K1,
+	uc.MIPS_REG_GP,
+	uc.MIPS_REG_SP,
+	uc.MIPS_REG_FP,
+	uc.MIPS_REG_RA,
+}
+
+//o32, $k0 and $k1 belong to the kernel. Position independent code derives $gp from the function address in $t9.
+var abi_o32 = ABI{
+	Arguments: []int{uc.MIPS_REG_A0, uc.MIPS_REG_A1, uc.MIPS_REG_A2, uc.MIPS_REG_A3},
+	Ret:       uc.MIPS_REG_V0,
+	CalleeSaved: []int{uc.MIPS_REG_S0, uc.MIPS_REG_S1, uc.MIPS_REG_S2, uc.MIPS_REG_S3, uc.MIPS_REG_S4, uc.MIPS_REG_S5,
+		uc.MIPS_REG_S6, uc.MIPS_REG_S7, uc.MIPS_REG_FP},
+	Scratch: []int{uc.MIPS_REG_AT, uc.MIPS_REG_V1, uc.MIPS_REG_T0, uc.MIPS_REG_T1, uc.MIPS_REG_T2, uc.MIPS_REG_T3,
+		uc.MIPS_REG_T4, uc.MIPS_REG_T5, uc.MIPS_REG_T6, uc.MIPS_REG_T7, uc.MIPS_REG_T8, uc.MIPS_REG_RA},
+	StackAlign: 8,
+	HomeSpace:  16,
+	Entry:      uc.MIPS_REG_T9,
+}
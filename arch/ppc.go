@@ -0,0 +1,158 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//32 bit PowerPC, big endian. r1 is the stack pointer, gcc uses r31 as frame pointer.
+type ArchPPC struct {}
+
+func (s *ArchPPC) GetRegStack() int {return uc.PPC_REG_1}
+func (s *ArchPPC) GetRegIP() int {return uc.PPC_REG_PC}
+func (s *ArchPPC) GetRegStackBase() int {return uc.PPC_REG_31}
+func (s *ArchPPC) GetRegLink() int {return uc.PPC_REG_LR}
+func (s *ArchPPC) GetPointerSize() int {return 4}
+func (s *ArchPPC) GetByteOrder() binary.ByteOrder {return binary.BigEndian}
+func (s *ArchPPC) GetABI() *ABI {return &abi_sysv_ppc}
+func (s *ArchPPC) ToUnicornArchDescription() int {return uc.ARCH_PPC}
+func (s *ArchPPC) ToUnicornModeDescription() int {return uc.MODE_PPC32 | uc.MODE_BIG_ENDIAN}
+func (s *ArchPPC) ToCapstoneArchDescription() int {return gapstone.CS_ARCH_PPC}
+func (s *ArchPPC) ToCapstoneModeDescription() int {return gapstone.CS_MODE_32 | gapstone.CS_MODE_BIG_ENDIAN}
+
+//the general purpose registers are 0-31, gcc numbers the link register 65 and the count register 66
+func (s *ArchPPC) GetDwarfRegister(num uint64) (int, bool) {
+	switch {
+	case num < 32:
+		return regs_by_index_ppc[num], true
+	case num == 65:
+		return uc.PPC_REG_LR, true
+	case num == 66:
+		return uc.PPC_REG_CTR, true
+	}
+	return 0, false
+}
+
+const (
+	ppc_op_bc     = 16
+	ppc_op_sc     = 17
+	ppc_op_b      = 18
+	ppc_op_xl     = 19 //bclr and bcctr, told apart by their extended opcode
+	ppc_xo_bclr   = 16
+	ppc_xo_bcctr  = 528
+	ppc_trap      = 0x7fe00008 //tw 31, 0, 0
+	ppc_bo_always = 0x14       //ignore both the condition and the count register
+)
+
+//Capstone names conditional branches by their simplified mnemonics (beq, bdnz, bnelr, ...), so they are classified by
+//their encoding instead of their id. The conditional bclr and bcctr stay returns and indirect jumps.
+func (s *ArchPPC) Classify(ins *gapstone.Instruction) InstructionClass {
+	if len(ins.Bytes) < 4 {
+		return ClassNone
+	}
+	word := binary.BigEndian.Uint32(ins.Bytes)
+	op, xo, bo, link := word>>26, (word>>1)&0x3ff, (word>>21)&0x1f, word&1 != 0
+	switch {
+	case word == ppc_trap:
+		return ClassTrap
+	case op == ppc_op_sc:
+		return ClassSyscall
+	case op == ppc_op_bc && link && word&0xfffe == 4:
+		//bcl 20, 31, next only loads the address of the next instruction into the link register
+		return ClassNone
+	case link && (op == ppc_op_b || op == ppc_op_bc || op == ppc_op_xl && (xo == ppc_xo_bclr || xo == ppc_xo_bcctr)):
+		return ClassCall //bl, bcl and the calls through the link (blrl) or count register (bctrl)
+	case op == ppc_op_b, op == ppc_op_bc && bo&ppc_bo_always == ppc_bo_always:
+		return ClassJump
+	case op == ppc_op_bc:
+		return ClassConditional
+	case op == ppc_op_xl && xo == ppc_xo_bclr:
+		return ClassReturn
+	case op == ppc_op_xl && xo == ppc_xo_bcctr:
+		return ClassIndirect
+	}
+	return ClassNone
+}
+
+func ppc_word(ins *gapstone.Instruction) uint32 {
+	if len(ins.Bytes) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(ins.Bytes)
+}
+
+func sign_extend(val uint32, bits uint) uint32 {
+	return uint32(int32(val<<(32-bits)) >> (32 - bits))
+}
+
+//PPCBranchTarget decodes the label of b and bc, relative unless the absolute address bit is set
+func PPCBranchTarget(ins *gapstone.Instruction) (uint64, bool) {
+	word := ppc_word(ins)
+	var offset uint32
+	switch word >> 26 {
+	case ppc_op_b:
+		offset = sign_extend(word&0x03fffffc, 26)
+	case ppc_op_bc:
+		offset = sign_extend(word&0xfffc, 16)
+	default:
+		return 0, false
+	}
+	if word&2 != 0 {
+		return uint64(offset), true
+	}
+	return uint64(uint32(ins.Address) + offset), true
+}
+
+//PPCBranchAlways is true for branches that ignore both the condition and the count register, the conditional bclr and
+//bcctr fall through
+func PPCBranchAlways(ins *gapstone.Instruction) bool {
+	return (ppc_word(ins)>>21)&ppc_bo_always == ppc_bo_always
+}
+
+var regs_by_index_ppc = []int{
+	uc.PPC_REG_0,
+	uc.PPC_REG_1,
+	uc.PPC_REG_2,
+	uc.PPC_REG_3,
+	uc.PPC_REG_4,
+	uc.PPC_REG_5,
+	uc.PPC_REG_6,
+	uc.PPC_REG_7,
+	uc.PPC_REG_8,
+	uc.PPC_REG_9,
+	uc.PPC_REG_10,
+	uc.PPC_REG_11,
+	uc.PPC_REG_12,
+	uc.PPC_REG_13,
+	uc.PPC_REG_14,
+	uc.PPC_REG_15,
+	uc.PPC_REG_16,
+	uc.PPC_REG_17,
+	uc.PPC_REG_18,
+	uc.PPC_REG_19,
+	uc.PPC_REG_20,
+	uc.PPC_REG_21,
+	uc.PPC_REG_22,
+	uc.PPC_REG_23,
+	uc.PPC_REG_24,
+	uc.PPC_REG_25,
+	uc.PPC_REG_26,
+	uc.PPC_REG_27,
+	uc.PPC_REG_28,
+	uc.PPC_REG_29,
+	uc.PPC_REG_30,
+	uc.PPC_REG_31,
+	uc.PPC_REG_LR,
+	uc.PPC_REG_CTR,
+}
+
+//System V PowerPC ABI, r2 and r13 are reserved for the system and the small data area
+var abi_sysv_ppc = ABI{
+	Arguments: []int{uc.PPC_REG_3, uc.PPC_REG_4, uc.PPC_REG_5, uc.PPC_REG_6, uc.PPC_REG_7, uc.PPC_REG_8, uc.PPC_REG_9,
+		uc.PPC_REG_10},
+	Ret:         uc.PPC_REG_3,
+	CalleeSaved: regs_by_index_ppc[14:32],
+	Scratch:     []int{uc.PPC_REG_0, uc.PPC_REG_11, uc.PPC_REG_12, uc.PPC_REG_LR, uc.PPC_REG_CTR},
+	StackAlign:  16,
+}
@@ -0,0 +1,237 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//The Capstone version gapstone binds predates RISC-V, these are the numbers Capstone 5 uses. The disassembler never
+//passes them to Capstone, it splits and classifies RISC-V code on its own.
+const (
+	CS_ARCH_RISCV   = 15
+	CS_MODE_RISCV64 = 1 << 1
+	CS_MODE_RISCVC  = 1 << 2 //compressed instructions
+)
+
+//64 bit RISC-V (RV64GC), little endian. Calls leave their return address in ra, the C extension mixes 2 byte
+//instructions into the 4 byte ones.
+type ArchRISCV64 struct {}
+
+func (s *ArchRISCV64) GetRegStack() int {return uc.RISCV_REG_SP}
+func (s *ArchRISCV64) GetRegIP() int {return uc.RISCV_REG_PC}
+func (s *ArchRISCV64) GetRegStackBase() int {return uc.RISCV_REG_S0}
+func (s *ArchRISCV64) GetRegLink() int {return uc.RISCV_REG_RA}
+func (s *ArchRISCV64) GetPointerSize() int {return 8}
+func (s *ArchRISCV64) GetByteOrder() binary.ByteOrder {return binary.LittleEndian}
+func (s *ArchRISCV64) GetABI() *ABI {return &abi_lp64}
+func (s *ArchRISCV64) ToUnicornArchDescription() int {return uc.ARCH_RISCV}
+func (s *ArchRISCV64) ToUnicornModeDescription() int {return uc.MODE_RISCV64}
+func (s *ArchRISCV64) ToCapstoneArchDescription() int {return CS_ARCH_RISCV}
+func (s *ArchRISCV64) ToCapstoneModeDescription() int {return CS_MODE_RISCV64 | CS_MODE_RISCVC}
+
+//the integer registers are 0-31
+func (s *ArchRISCV64) GetDwarfRegister(num uint64) (int, bool) {
+	if num > 31 {
+		return 0, false
+	}
+	if num == 0 {
+		return uc.RISCV_REG_ZERO, true
+	}
+	return regs_by_index_riscv[num-1], true
+}
+
+const (
+	riscv_reg_zero = 0
+	riscv_reg_ra   = 1
+
+	riscv_op_auipc  = 0x17
+	riscv_op_branch = 0x63
+	riscv_op_jalr   = 0x67
+	riscv_op_jal    = 0x6f
+
+	riscv_ecall    = 0x00000073
+	riscv_ebreak   = 0x00100073
+	riscv_unimp    = 0xc0001073 //csrrw x0, cycle, x0
+	riscv_c_ebreak = 0x9002
+	riscv_c_unimp  = 0x0000
+)
+
+//RISC-V code is classified by its encoding, see CS_ARCH_RISCV. ret is jalr x0, 0(ra) and c.jr ra. A jalr to a
+//register an auipc set up is a call or tail call to a known target, only the disassembler tracks the auipc.
+func (s *ArchRISCV64) Classify(ins *gapstone.Instruction) InstructionClass {
+	if len(ins.Bytes) == 2 {
+		word := uint32(binary.LittleEndian.Uint16(ins.Bytes))
+		rs1 := word >> 7 & 0x1f
+		switch {
+		case word == riscv_c_ebreak, word == riscv_c_unimp:
+			return ClassTrap
+		case word&0xe003 == 0xa001: //c.j
+			return ClassJump
+		case word&0xe003 == 0xc001, word&0xe003 == 0xe001: //c.beqz, c.bnez
+			return ClassConditional
+		case word&0xf07f == 0x8002 && rs1 == riscv_reg_ra: //c.jr ra
+			return ClassReturn
+		case word&0xf07f == 0x8002 && rs1 != riscv_reg_zero: //c.jr
+			return ClassIndirect
+		case word&0xf07f == 0x9002 && rs1 != riscv_reg_zero: //c.jalr
+			return ClassCall
+		}
+		return ClassNone
+	}
+	if len(ins.Bytes) != 4 {
+		return ClassNone
+	}
+	word := binary.LittleEndian.Uint32(ins.Bytes)
+	rd, rs1 := word>>7&0x1f, word>>15&0x1f
+	switch {
+	case word == riscv_ecall:
+		return ClassSyscall
+	case word == riscv_ebreak, word == riscv_unimp:
+		return ClassTrap
+	case word&0x7f == riscv_op_jal && rd == riscv_reg_zero:
+		return ClassJump
+	case word&0x7f == riscv_op_jal:
+		return ClassCall
+	case word&0x7f == riscv_op_branch:
+		return ClassConditional
+	case word&0x707f == riscv_op_jalr && rd != riscv_reg_zero:
+		return ClassCall
+	case word&0x707f == riscv_op_jalr && rs1 == riscv_reg_ra && word>>20 == 0:
+		return ClassReturn
+	case word&0x707f == riscv_op_jalr:
+		return ClassIndirect
+	}
+	return ClassNone
+}
+
+func riscv_word(ins *gapstone.Instruction) uint32 {
+	switch len(ins.Bytes) {
+	case 2:
+		return uint32(binary.LittleEndian.Uint16(ins.Bytes))
+	case 4:
+		return binary.LittleEndian.Uint32(ins.Bytes)
+	}
+	return 0
+}
+
+func riscv_bit(word uint32, from, to uint) uint32 {
+	return (word >> from & 1) << to
+}
+
+func riscv_imm_i(word uint32) uint64 { return uint64(int64(int32(word) >> 20)) }
+func riscv_imm_u(word uint32) uint64 { return uint64(int64(int32(word & 0xfffff000))) }
+
+func riscv_imm_j(word uint32) uint64 {
+	imm := word&0xff000 | riscv_bit(word, 20, 11) | (word>>21&0x3ff)<<1 | riscv_bit(word, 31, 20)
+	return uint64(int64(int32(sign_extend(imm, 21))))
+}
+
+func riscv_imm_b(word uint32) uint64 {
+	imm := (word>>8&0xf)<<1 | (word>>25&0x3f)<<5 | riscv_bit(word, 7, 11) | riscv_bit(word, 31, 12)
+	return uint64(int64(int32(sign_extend(imm, 13))))
+}
+
+//the offset of c.j (and c.jal of RV32)
+func riscv_imm_cj(word uint32) uint64 {
+	imm := (word>>3&7)<<1 | riscv_bit(word, 11, 4) | riscv_bit(word, 2, 5) | riscv_bit(word, 7, 6) | riscv_bit(word, 6, 7) |
+		(word>>9&3)<<8 | riscv_bit(word, 8, 10) | riscv_bit(word, 12, 11)
+	return uint64(int64(int32(sign_extend(imm, 12))))
+}
+
+//the offset of c.beqz and c.bnez
+func riscv_imm_cb(word uint32) uint64 {
+	imm := (word>>3&3)<<1 | (word>>10&3)<<3 | riscv_bit(word, 2, 5) | (word>>5&3)<<6 | riscv_bit(word, 12, 8)
+	return uint64(int64(int32(sign_extend(imm, 9))))
+}
+
+//RISCVTarget decodes the pc relative destination of jal, the conditional branches, c.j, c.beqz and c.bnez
+func RISCVTarget(ins *gapstone.Instruction) (uint64, bool) {
+	word := riscv_word(ins)
+	addr := uint64(ins.Address)
+	if len(ins.Bytes) == 2 {
+		switch {
+		case word&0xe003 == 0xa001: //c.j
+			return addr + riscv_imm_cj(word), true
+		case word&0xe003 == 0xc001, word&0xe003 == 0xe001: //c.beqz, c.bnez
+			return addr + riscv_imm_cb(word), true
+		}
+		return 0, false
+	}
+	switch {
+	case word&0x7f == riscv_op_jal:
+		return addr + riscv_imm_j(word), true
+	case word&0x7f == riscv_op_branch:
+		return addr + riscv_imm_b(word), true
+	}
+	return 0, false
+}
+
+//RISCVJalr decodes the base register and the offset of a jalr
+func RISCVJalr(ins *gapstone.Instruction) (uint32, uint64, bool) {
+	word := riscv_word(ins)
+	if len(ins.Bytes) != 4 || word&0x707f != riscv_op_jalr {
+		return 0, 0, false
+	}
+	return word >> 15 & 0x1f, riscv_imm_i(word), true
+}
+
+//RISCVAuipc decodes the register an auipc writes and the address it stores there, auipc to x0 does nothing
+func RISCVAuipc(ins *gapstone.Instruction) (uint32, uint64, bool) {
+	word := riscv_word(ins)
+	rd := word >> 7 & 0x1f
+	if len(ins.Bytes) != 4 || word&0x7f != riscv_op_auipc || rd == riscv_reg_zero {
+		return 0, 0, false
+	}
+	return rd, uint64(ins.Address) + riscv_imm_u(word), true
+}
+
+//x0 is left out, it can't be written. In the order of the register numbers.
+var regs_by_index_riscv = []int{
+	uc.RISCV_REG_X1,
+	uc.RISCV_REG_X2,
+	uc.RISCV_REG_X3,
+	uc.RISCV_REG_X4,
+	uc.RISCV_REG_X5,
+	uc.RISCV_REG_X6,
+	uc.RISCV_REG_X7,
+	uc.RISCV_REG_X8,
+	uc.RISCV_REG_X9,
+	uc.RISCV_REG_X10,
+	uc.RISCV_REG_X11,
+	uc.RISCV_REG_X12,
+	uc.RISCV_REG_X13,
+	uc.RISCV_REG_X14,
+	uc.RISCV_REG_X15,
+	uc.RISCV_REG_X16,
+	uc.RISCV_REG_X17,
+	uc.RISCV_REG_X18,
+	uc.RISCV_REG_X19,
+	uc.RISCV_REG_X20,
+	uc.RISCV_REG_X21,
+	uc.RISCV_REG_X22,
+	uc.RISCV_REG_X23,
+	uc.RISCV_REG_X24,
+	uc.RISCV_REG_X25,
+	uc.RISCV_REG_X26,
+	uc.RISCV_REG_X27,
+	uc.RISCV_REG_X28,
+	uc.RISCV_REG_X29,
+	uc.RISCV_REG_X30,
+	uc.RISCV_REG_X31,
+}
+
+//RISC-V ELF psABI, integer calling convention of LP64. gp points to the small data area (__global_pointer$), tp to the
+//thread control block, both are set up by the runtime.
+var abi_lp64 = ABI{
+	Arguments: []int{uc.RISCV_REG_A0, uc.RISCV_REG_A1, uc.RISCV_REG_A2, uc.RISCV_REG_A3, uc.RISCV_REG_A4, uc.RISCV_REG_A5,
+		uc.RISCV_REG_A6, uc.RISCV_REG_A7},
+	Ret: uc.RISCV_REG_A0,
+	CalleeSaved: []int{uc.RISCV_REG_S0, uc.RISCV_REG_S1, uc.RISCV_REG_S2, uc.RISCV_REG_S3, uc.RISCV_REG_S4,
+		uc.RISCV_REG_S5, uc.RISCV_REG_S6, uc.RISCV_REG_S7, uc.RISCV_REG_S8, uc.RISCV_REG_S9, uc.RISCV_REG_S10,
+		uc.RISCV_REG_S11},
+	Scratch: []int{uc.RISCV_REG_RA, uc.RISCV_REG_T0, uc.RISCV_REG_T1, uc.RISCV_REG_T2, uc.RISCV_REG_T3, uc.RISCV_REG_T4,
+		uc.RISCV_REG_T5, uc.RISCV_REG_T6},
+	StackAlign:    16,
+	GlobalPointer: uc.RISCV_REG_GP,
+}
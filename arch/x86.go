@@ -0,0 +1,111 @@
+package arch
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//32 bit x86 (i386)
+type ArchX86 struct {}
+
+func (s *ArchX86) GetRegStack() int {return uc.X86_REG_ESP}
+func (s *ArchX86) GetRegIP() int {return uc.X86_REG_EIP}
+func (s *ArchX86) GetRegStackBase() int {return uc.X86_REG_EBP}
+func (s *ArchX86) GetPointerSize() int {return 4}
+func (s *ArchX86) GetByteOrder() binary.ByteOrder {return binary.LittleEndian}
+func (s *ArchX86) GetABI() *ABI {return &abi_cdecl}
+func (s *ArchX86) ToUnicornArchDescription() int {return uc.ARCH_X86}
+func (s *ArchX86) ToUnicornModeDescription() int {return uc.MODE_32}
+func (s *ArchX86) ToCapstoneArchDescription() int {return gapstone.CS_ARCH_X86}
+func (s *ArchX86) ToCapstoneModeDescription() int {return gapstone.CS_MODE_32}
+
+//System V i386 ABI, table 2.14
+func (s *ArchX86) GetDwarfRegister(num uint64) (int, bool) {
+	if num >= uint64(len(dwarf_regs_x86)) {
+		return 0, false
+	}
+	return dwarf_regs_x86[num], true
+}
+
+func (s *ArchX86) Classify(ins *gapstone.Instruction) InstructionClass {return classify_x86(ins)}
+
+//classify_x86 is shared by i386 and x86-64. Returns include ret imm16, retf and iret, the rep prefixes don't change
+//the id Capstone assigns.
+func classify_x86(ins *gapstone.Instruction) InstructionClass {
+	switch ins.Id {
+	case gapstone.X86_INS_RET, gapstone.X86_INS_RETF, gapstone.X86_INS_IRET, gapstone.X86_INS_IRETD,
+		gapstone.X86_INS_IRETQ:
+		return ClassReturn
+	case gapstone.X86_INS_CALL, gapstone.X86_INS_LCALL:
+		return ClassCall
+	case gapstone.X86_INS_JMP, gapstone.X86_INS_LJMP:
+		if ops := ins.X86.Operands; len(ops) > 0 && ops[0].Type == gapstone.X86_OP_IMM {
+			return ClassJump
+		}
+		return ClassIndirect
+	case gapstone.X86_INS_SYSCALL, gapstone.X86_INS_SYSENTER:
+		return ClassSyscall
+	case gapstone.X86_INS_INT:
+		if ops := ins.X86.Operands; len(ops) == 1 && x86_syscall_vectors[ops[0].Imm] {
+			return ClassSyscall
+		}
+		return ClassTrap
+	case gapstone.X86_INS_HLT, gapstone.X86_INS_UD2, gapstone.X86_INS_INT3, gapstone.X86_INS_INT1:
+		return ClassTrap
+	}
+	if x86_conditional[ins.Id] {
+		return ClassConditional
+	}
+	return ClassNone
+}
+
+//int 0x80 (Linux) and int 0x2e (Windows) enter the kernel, the other vectors are exceptions (int 0x29 is fastfail)
+var x86_syscall_vectors = map[int64]bool{0x80: true, 0x2e: true}
+
+//jcc, the jumps on the count register and the loops
+var x86_conditional = map[uint]bool{
+	gapstone.X86_INS_JA:     true,
+	gapstone.X86_INS_JAE:    true,
+	gapstone.X86_INS_JB:     true,
+	gapstone.X86_INS_JBE:    true,
+	gapstone.X86_INS_JE:     true,
+	gapstone.X86_INS_JNE:    true,
+	gapstone.X86_INS_JG:     true,
+	gapstone.X86_INS_JGE:    true,
+	gapstone.X86_INS_JL:     true,
+	gapstone.X86_INS_JLE:    true,
+	gapstone.X86_INS_JO:     true,
+	gapstone.X86_INS_JNO:    true,
+	gapstone.X86_INS_JS:     true,
+	gapstone.X86_INS_JNS:    true,
+	gapstone.X86_INS_JP:     true,
+	gapstone.X86_INS_JNP:    true,
+	gapstone.X86_INS_JCXZ:   true,
+	gapstone.X86_INS_JECXZ:  true,
+	gapstone.X86_INS_JRCXZ:  true,
+	gapstone.X86_INS_LOOP:   true,
+	gapstone.X86_INS_LOOPE:  true,
+	gapstone.X86_INS_LOOPNE: true,
+}
+
+var dwarf_regs_x86 = []int{
+	uc.X86_REG_EAX,
+	uc.X86_REG_ECX,
+	uc.X86_REG_EDX,
+	uc.X86_REG_EBX,
+	uc.X86_REG_ESP,
+	uc.X86_REG_EBP,
+	uc.X86_REG_ESI,
+	uc.X86_REG_EDI,
+	uc.X86_REG_EIP,
+}
+
+//System V i386 ABI, 2.2: all arguments are passed on the stack
+var abi_cdecl = ABI{
+	Ret:               uc.X86_REG_EAX,
+	CalleeSaved:       []int{uc.X86_REG_EBX, uc.X86_REG_EBP, uc.X86_REG_ESI, uc.X86_REG_EDI},
+	Scratch:           []int{uc.X86_REG_ECX, uc.X86_REG_EDX},
+	StackAlign:        16,
+	ReturnAddressSize: 4,
+}
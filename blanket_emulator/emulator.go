@@ -16,6 +16,8 @@ import (
 // current stack frame
 const ignore_stackframe_above_stack_pointer_size = 128
 
+//Both windows are only used if the stack frame of the function is unknown (see FrameInfo)
+
 //Ignore reads/writes below the initial stack pointer. We need to ignore some amount below the stack pointer, since
 //there will be no correct stacksetup if we start from some intermediate basic block. Leave/Return will then read from an
 //address below the stackframe
@@ -33,6 +35,10 @@ const resolve_static_addresses = true
 
 var Panic_on_known_bugs = false
 
+//the Environment register the stack pointer is derived from, the registers of the calling convention take the indices
+//below it (see arch.ABI.Registers)
+const env_stack_register = 64
+
 type Emulator struct {
 	Trace                    *Trace
 	WorkingSet               *WorkingSet
@@ -40,10 +46,18 @@ type Emulator struct {
 	Config                   Config
   Events                   *EventSet
 	mu                       uc.Unicorn
+	decoder                  *disasm.Decoder //classifies the executed instructions, see OnInstruction
+	snapshot                 *snapshot       //the traces of a FullBlanket start from, unless Config.FreshEngine is set
 	codepages                map[uint64]([]byte)
 	binaryContentPages       map[ds.Range]bool
 	staticAddresses          map[uint64]uint64
+	Heap                     *Heap
 	last_instruction_was_ret bool
+	return_pending           bool   //a return with delay slot was executed, see flush_return
+	delay_slot               uint64 //address of the delay slot of the pending return
+	cfa                      uint64 //canonical frame address of the emulated function, if frame_known
+	frame_size               uint64
+	frame_known              bool
 }
 
 type Config struct {
@@ -52,6 +66,18 @@ type Config struct {
 	MaxTracePages            int
 	Arch                     arch.Arch
 	Mode                     int
+	Library                  Library           //simulated imports, nil disables the interception of library calls
+	Imports                  map[uint64]string //fake import address (ds.ImportAddress) -> import name
+	Frame                    FrameInfo         //stack frame of the emulated function, nil falls back to fixed windows
+	FreshEngine              bool              //create a new engine for every trace instead of restoring a snapshot
+	Function                 uint64            //entry of the emulated function, stored in ABI.Entry
+	GlobalPointer            uint64            //value of ABI.GlobalPointer, 0 leaves the register alone
+}
+
+// FrameInfo describes the stack frame of a function, e.g. by its unwind information
+type FrameInfo interface {
+	CFA(addr uint64) (int, int64, bool) //register and offset that yield the canonical frame address at addr
+	Size() (uint64, bool)               //largest distance between the stack pointer and the canonical frame address
 }
 
 func wrap(err error) *errors.Error {
@@ -86,14 +112,18 @@ func (s *Emulator) ReadEvent(addr uint64) {
 	s.Events.Add(ReadEvent(addr))
 }
 
-func (s *Emulator) SyscallEvent(number uint64) {
-	s.Events.Add(SyscallEvent(number))
+func (s *Emulator) SyscallEvent(number uint64, args [max_syscall_event_args]uint64) {
+	s.Events.Add(SyscallEvent{Number: number, Args: args})
 }
 
 func (s *Emulator) ReturnEvent(number uint64) {
 	s.Events.Add(ReturnEvent(number))
 }
 
+func (s *Emulator) CallEvent(name string, args [max_call_event_args]uint64) {
+	s.Events.Add(CallEvent{Name: name, Args: args})
+}
+
 func (s *Emulator) InvalidInstructionEvent(offset uint64) {
 	s.Events.Add(InvalidInstructionEvent(offset))
 }
@@ -103,7 +133,12 @@ func mapLoadableRangeToStarts(mem map[ds.Range]*ds.MappedRegion) map[uint64][]by
 	res := make(map[uint64][]byte)
 	for key, val := range mem {
 		if val.Loaded {
-			res[key.From] = (*val).Data
+			data := (*val).Data
+			if uint64(len(data)) < key.Length() { //memory beyond the file content (.bss) reads as zero, not as Env memory
+				data = make([]byte, key.Length())
+				copy(data, (*val).Data)
+			}
+			res[key.From] = data
 		}
 	}
 	return res
@@ -125,6 +160,7 @@ func NewEmulator(mem map[ds.Range]*ds.MappedRegion, conf Config, env Environment
 	res.binaryContentPages = getSetOfOriginalContentPages(mem)
 	res.staticAddresses = make(map[uint64]uint64)
   res.Events = NewEventSet()
+	res.Heap = NewHeap(conf.Arch.GetPointerSize())
 	return res
 }
 
@@ -138,6 +174,11 @@ func (s *Emulator) CreateUnicorn() *errors.Error {
 		return errors.Wrap(err2, 0)
 	}
 	s.mu = mu
+	decoder, err2 := disasm.NewDecoder(s.Config.Arch)
+	if err2 != nil {
+		return errors.Wrap(err2, 0)
+	}
+	s.decoder = decoder
 	err := s.addHooks()
 	if err != nil {
 		return errors.Wrap(err, 0)
@@ -146,6 +187,11 @@ func (s *Emulator) CreateUnicorn() *errors.Error {
 	if err := s.ResetMemoryImage(); err != nil {
 		return err
 	}
+	if !s.Config.FreshEngine {
+		if err := s.take_snapshot(); err != nil {
+			return err
+		}
+	}
 	if err := s.ResetWorkingSet(); err != nil {
 		return err
 	}
@@ -156,11 +202,29 @@ func (s *Emulator) CreateUnicorn() *errors.Error {
 }
 
 func (s *Emulator) Close() *errors.Error {
+	if s.decoder != nil {
+		s.decoder.Close()
+		s.decoder = nil
+	}
 	mu := s.mu
 	s.mu = nil
+	s.snapshot = nil
 	return wrap(mu.Close())
 }
 
+// ResetEngine prepares the engine for the next trace. The engine of the previous trace is restored to the snapshot
+// taken once the image was loaded, a new one is only created for the first trace or if Config.FreshEngine is set.
+func (s *Emulator) ResetEngine() *errors.Error {
+	if s.Config.FreshEngine || s.mu == nil || s.snapshot == nil {
+		return s.CreateUnicorn()
+	}
+	if err := s.restore_snapshot(); err != nil {
+		return err
+	}
+	s.WorkingSet = NewWorkingSet(s.Config.MaxTracePages)
+	return s.ResetRegisters()
+}
+
 type UIntArray ([]uint64)
 
 func (s UIntArray) Len() int           { return len(s) }
@@ -183,6 +247,7 @@ func (s *Emulator) MapPageForRange(addr uint64, length uint64) *errors.Error {
 		page_start := addr - (addr % pagesize)
 		page_end := data_end + 4096 - data_end%4096
 		page_size := page_end - page_start
+		s.preserve(page_start, page_size)
 		s.mu.MemUnmap(page_start, page_size)
 		log.WithFields(log.Fields{"addr": hex(page_start), "length": page_size}).Debug("Map Memory for range")
 		if err := s.mu.MemMapProt(page_start, page_size, uc.PROT_WRITE); err != nil {
@@ -255,6 +320,8 @@ func (s *Emulator) FullBlanket(blocks_to_visit map[uint64]ds.BB) *errors.Error {
 	max_blocks_number := len(blocks_to_visit)
 
 	s.Trace = NewTrace(&blocks_to_visit)
+	s.Heap = NewHeap(s.Config.Arch.GetPointerSize()) //shared by all traces, the registers of a dumped State may still point into the heap
+	s.snapshot = nil                                   //the first trace creates the engine for the current Config
 	for i := 0; i < max_blocks_number; i++ {
 		bb, state := s.Trace.FirstUnseenBlock()
 
@@ -280,7 +347,7 @@ func (s *Emulator) handle_emulator_error(err error) *errors.Error {
 		return nil
 	}
 	uc_err := err.(uc.UcError)
-	ip, _ := s.mu.RegRead(uc.X86_REG_RIP)
+	ip, _ := s.mu.RegRead(s.Config.Arch.GetRegIP())
 	log.WithFields(log.Fields{"err": err, "ip": hex(ip)}).Debug("Emulator Error Occured")
 
 	if uc_err == uc.ERR_READ_PROT || uc_err == uc.ERR_WRITE_PROT {
@@ -300,7 +367,7 @@ func (s *Emulator) handle_emulator_error(err error) *errors.Error {
 }
 
 func (s *Emulator) RunOneTrace( addr uint64, state *State ) *errors.Error { //TODO is ^uint64(0) the right way to ignore the end?
-	cerr := s.CreateUnicorn()
+	cerr := s.ResetEngine()
 
 
 	if cerr != nil {
@@ -311,10 +378,17 @@ func (s *Emulator) RunOneTrace( addr uint64, state *State ) *errors.Error { //TO
     if err != nil {return nil}
   }
 
+	s.locateFrame(addr)
 	log.WithFields(log.Fields{"addr": hex(addr)}).Info("Run One Trace")
 	opt := uc.UcOptions{Timeout: s.Config.MaxTraceTime, Count: s.Config.MaxTraceInstructionCount}
-	err := s.mu.StartWithOptions(addr, ^uint64(0), &opt)
+	start := addr
+	if arm, ok := s.Config.Arch.(*arch.ArchARM); ok {
+		start = arm.StartAddress(addr)
+	}
+	s.return_pending = false
+	err := s.mu.StartWithOptions(start, ^uint64(0), &opt)
 	log.WithFields(log.Fields{"addr": hex(addr)}).Debug("Finished One Trace")
+	s.flush_return()
 	return s.handle_emulator_error(err)
 }
 
@@ -327,44 +401,68 @@ func (s *Emulator) ResetMemoryImage() *errors.Error {
 	return s.WriteMemoryMap(s.codepages)
 }
 
+// ResetRegisters fills the registers of the calling convention from the Env, the n-th argument always starts with the
+// Environment register n. The stack pointer is aligned as at a call, below the return address the call pushed, with the
+// frame pointer 50 slots above it. Registers the ABI reserves for the function address and the global pointer get their
+// values from the Config instead.
 func (s *Emulator) ResetRegisters() *errors.Error {
-
-  for i,reg := range s.Config.Arch.GetRegisters() {
-    if err := s.mu.RegWrite(reg, s.Env.GetReg(i)); err != nil {
-      return wrap(err)
-    }
-  }
-
-	if err := s.mu.RegWrite(uc.X86_REG_RAX, s.Env.GetReg(1)); err != nil {
-		return wrap(err)
+	architecture := s.Config.Arch
+	abi := architecture.GetABI()
+	for i, reg := range abi.Registers() {
+		if err := s.mu.RegWrite(reg, s.Env.GetReg(i)); err != nil {
+			return wrap(err)
+		}
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RBX, s.Env.GetReg(2)); err != nil {
-		return wrap(err)
+	if abi.Entry != 0 {
+		if err := s.mu.RegWrite(abi.Entry, s.Config.Function); err != nil {
+			return wrap(err)
+		}
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RDX, s.Env.GetReg(3)); err != nil {
-		return wrap(err)
+	if abi.GlobalPointer != 0 && s.Config.GlobalPointer != 0 {
+		if err := s.mu.RegWrite(abi.GlobalPointer, s.Config.GlobalPointer); err != nil {
+			return wrap(err)
+		}
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RCX, s.Env.GetReg(4)); err != nil {
+	seed := s.Env.GetReg(env_stack_register)
+	stack := seed - seed%abi.StackAlign - abi.ReturnAddressSize
+	if err := s.mu.RegWrite(architecture.GetRegStack(), stack); err != nil {
 		return wrap(err)
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RSP, s.Env.GetReg(5)-s.Env.GetReg(5)%4096); err != nil {
+	if err := s.mu.RegWrite(architecture.GetRegStackBase(), stack+50*uint64(architecture.GetPointerSize())); err != nil {
 		return wrap(err)
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RBP, s.Env.GetReg(5)-s.Env.GetReg(5)%4096+50*8); err != nil {
-		return wrap(err)
+	return nil
+}
+
+// locateFrame computes the canonical frame address for a trace starting at addr, it stays the same for the whole trace
+func (s *Emulator) locateFrame(addr uint64) {
+	s.frame_known = false
+	if s.Config.Frame == nil {
+		return
 	}
-	if err := s.mu.RegWrite(uc.X86_REG_RSI, s.Env.GetReg(7)); err != nil {
-		return wrap(err)
+	size, ok := s.Config.Frame.Size()
+	if !ok {
+		return
 	}
-
-	if err := s.mu.RegWrite(uc.X86_REG_RDI, s.Env.GetReg(8)); err != nil {
-		return wrap(err)
+	reg, offset, ok := s.Config.Frame.CFA(addr)
+	if !ok {
+		return
 	}
-
-	return nil
+	val, err := s.mu.RegRead(reg)
+	if err != nil {
+		return
+	}
+	s.cfa = val + uint64(offset)
+	s.frame_size = size
+	s.frame_known = true
+	log.WithFields(log.Fields{"cfa": hex(s.cfa), "size": size}).Debug("Located stack frame")
 }
 
 func (s *Emulator) is_in_stack_frame(addr uint64) bool {
+	if s.frame_known { //the frame, the red zone below it and arguments passed on the stack
+		lowest := s.cfa - s.frame_size - s.Config.Arch.GetABI().RedZone
+		return lowest <= addr && addr <= s.cfa+ignore_stackframe_below_initial_stack_pointer_size
+	}
 	stack, _ := s.mu.RegRead(s.Config.Arch.GetRegStack())
 	is_above_initial_stack := addr <= stack+ignore_stackframe_below_initial_stack_pointer_size
 	is_below_current_stack := addr >= stack-ignore_stackframe_above_stack_pointer_size
@@ -413,10 +511,19 @@ func (s *Emulator) resolve_static(addr uint64) uint64 {
 	return next_val
 }
 
+// access_value is the value written by an access of size bytes, a 4 byte pointer must look the same no matter how
+// unicorn extends it
+func access_value(ivalue int64, size int) uint64 {
+	if size >= 8 {
+		return uint64(ivalue)
+	}
+	return uint64(ivalue) & (1<<(8*uint(size)) - 1)
+}
+
 func (s *Emulator) handleMemoryEvent(access int, addr uint64, size int, ivalue int64) {
-	addr = s.resolve_static(addr)
-	val := s.resolve_static(uint64(ivalue))
-	ip, _ := s.mu.RegRead(uc.X86_REG_RIP)
+	addr = s.resolve_address(addr)
+	val := s.resolve_address(access_value(ivalue, size))
+	ip, _ := s.mu.RegRead(s.Config.Arch.GetRegIP())
 
 	if size <= 0 {
 		panic("invalid write")
@@ -457,6 +564,10 @@ func (s *Emulator) OnBlock(addr uint64, size uint32){
 
 func (s *Emulator) OnInvalidMem(access int, addr uint64, size int, value int64) bool {
 		log.WithFields(log.Fields{"addr": hex(addr), "size": size}).Debug("invalid memory access")
+		if access == uc.MEM_FETCH_UNMAPPED && s.isLibraryCall(addr) {
+			return s.mapLibraryStub(addr)
+		}
+
 		if access == uc.MEM_FETCH_UNMAPPED || access == uc.MEM_FETCH_PROT {
 			return false
 		}
@@ -480,7 +591,7 @@ func (s *Emulator) OnInvalidMem(access int, addr uint64, size int, value int64)
 
 
   func (s* Emulator) OnInstruction(addr uint64, size uint32) {
-		rax, _ := s.mu.RegRead(s.Config.Arch.GetRegRet())
+		rax, _ := s.mu.RegRead(s.Config.Arch.GetABI().Ret)
 		rsp, _ := s.mu.RegRead(s.Config.Arch.GetRegStack())
 		rip, _ := s.mu.RegRead(s.Config.Arch.GetRegIP())
     if size <0 || size > 64 {
@@ -493,20 +604,57 @@ func (s *Emulator) OnInvalidMem(access int, addr uint64, size int, value int64)
       size = 64
     }
 		mem, _ := s.mu.MemRead(rip, uint64(size))
-		s.last_instruction_was_ret = false
+		if s.return_pending && addr != s.delay_slot {
+			s.flush_return()
+		}
+		s.last_instruction_was_ret = s.return_pending
 
-		if s.Config.Arch.IsRet(mem) { // special treatment for RET instruction
-			s.ReturnEvent(rax)
+		if s.isLibraryCall(addr) { // the ret of the library stub returns to the caller, it is no ReturnEvent
+			s.OnLibraryCall(addr)
+			return
+		}
+
+		class, dmp := arch.ClassNone, "DA Fail: invalid instruction"
+		if ins := s.decoder.Decode(addr, mem); ins != nil {
+			class, dmp = s.Config.Arch.Classify(ins), ins.Mnemonic+" "+ins.OpStr
+		}
+
+		if _, delayed := s.Config.Arch.(*arch.ArchMIPS); delayed && class == arch.ClassReturn {
+			s.return_pending, s.delay_slot = true, addr+uint64(size)
+			s.last_instruction_was_ret = true
+		} else if class == arch.ClassReturn { // special treatment for RET instruction
+			s.ReturnEvent(s.resolve_heap(rax))
 			log.WithFields(log.Fields{"at": hex(addr), "rax": hex(rax)}).Info("Ret Event")
 			s.last_instruction_was_ret = true
 		}
-    log.WithFields(log.Fields{"at": hex(addr), "size": size, "rax": hex(rax), "rsp": hex(rsp), "dmp":
-    disasm.InspectMemory(addr, mem)}).Debug("Instruction")
+    log.WithFields(log.Fields{"at": hex(addr), "size": size, "rax": hex(rax), "rsp": hex(rsp), "dmp": dmp}).Debug("Instruction")
     s.Trace.DumpStateIfEndOfBB(s, addr, size)
   }
 
 
 
+// flush_return adds the ReturnEvent of a pending return once its delay slot is executed, which often sets the return
+// value. The trace usually ends right after the delay slot, when the return address turns out to be unmapped.
+func (s *Emulator) flush_return() {
+	if !s.return_pending {
+		return
+	}
+	s.return_pending = false
+	ret, _ := s.mu.RegRead(s.Config.Arch.GetABI().Ret)
+	s.ReturnEvent(s.resolve_heap(ret))
+	log.WithFields(log.Fields{"at": hex(s.delay_slot), "ret": hex(ret)}).Info("Ret Event")
+}
+
+// addSyscallHook intercepts syscall on x86-64 and sysenter on i386, int 0x80 is handled by OnInterrupt
+func (s *Emulator) addSyscallHook() *errors.Error {
+	if s.Config.Arch.GetPointerSize() == 4 {
+		_, err := s.mu.HookAdd(uc.HOOK_INSN, func(mu uc.Unicorn) { s.OnSyscall(syscall_i386) }, uc.X86_INS_SYSENTER)
+		return wrap(err)
+	}
+	_, err := s.mu.HookAdd(uc.HOOK_INSN, func(mu uc.Unicorn) { s.OnSyscall(syscall_x86_64) }, uc.X86_INS_SYSCALL)
+	return wrap(err)
+}
+
 func (s *Emulator) addHooks() *errors.Error {
 
 	_, err := s.mu.HookAdd(uc.HOOK_BLOCK, func(mu uc.Unicorn, addr uint64, size uint32) {  
@@ -533,21 +681,16 @@ func (s *Emulator) addHooks() *errors.Error {
 		return wrap(err)
 	}
 
-	hook_inst_sys := func(mu uc.Unicorn) {
-		//rax, _ := mu.RegRead(uc.X86_REG_RAX)
-		//s.SyscallEvent(s,rax)
-		//log.WithFields(log.Fields{"num": rax}).Debug("Syscall/Interrupt")
+	if s.Config.Arch.ToUnicornArchDescription() == uc.ARCH_X86 {
+		if err := s.addSyscallHook(); err != nil {
+			return err
+		}
 	}
 
-	_, err = s.mu.HookAdd(uc.HOOK_INSN, hook_inst_sys, uc.X86_INS_SYSCALL)
+	_, err = s.mu.HookAdd(uc.HOOK_INTR, func(mu uc.Unicorn, intno uint32) { s.OnInterrupt(intno) })
 	if err != nil {
 		return wrap(err)
 	}
-
-	//_, err = s.mu.HookAdd(uc.HOOK_INTR, hook_inst_sys)
-	//if err != nil {
-	//	return wrap(err)
-	//}
   _,err = s.mu.HookAdd(uc.HOOK_CODE, func(mu uc.Unicorn, addr uint64, size uint32) { s.OnInstruction(addr, size) })
 
 	return wrap(err)
@@ -15,7 +15,14 @@ type WriteEvent struct {
 	Addr  uint64
 	Value uint64
 }
-type SyscallEvent uint64
+type SyscallEvent struct {
+	Number uint64
+	Args   [max_syscall_event_args]uint64
+}
+type CallEvent struct {
+	Name string
+	Args [max_call_event_args]uint64
+}
 type InvalidInstructionEvent uint64
 
 func (addr ReadEvent) Hash() uint64 {
@@ -27,7 +34,11 @@ func (s WriteEvent) Hash() uint64 {
 }
 
 func (s SyscallEvent) Hash() uint64 {
-	return SysEventHash(uint64(s))
+	return SysEventHash(s.Number, s.Args[:])
+}
+
+func (s CallEvent) Hash() uint64 {
+	return CallEventHash(s.Name, s.Args[:])
 }
 
 func (s ReturnEvent) Hash() uint64 {
@@ -51,7 +62,11 @@ func (s WriteEvent) Inspect() string {
 }
 
 func (s SyscallEvent) Inspect() string {
-	return fmt.Sprintf("Sys(%x)", s)
+	return fmt.Sprintf("Sys(%x(%x, %x, %x))", s.Number, s.Args[0], s.Args[1], s.Args[2])
+}
+
+func (s CallEvent) Inspect() string {
+	return fmt.Sprintf("Call(%s(%x, %x, %x))", s.Name, s.Args[0], s.Args[1], s.Args[2])
 }
 
 func (s InvalidInstructionEvent) Inspect() string {
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	ds "github.com/ranmrdrakono/indika/data_structures"
-	"github.com/ranmrdrakono/indika/disassemble"
 	"github.com/ranmrdrakono/indika/arch"
+	"github.com/ranmrdrakono/indika/loader"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
   "encoding/binary"
+  "bytes"
 	"reflect"
+	"sort"
 	"testing"
   "io/ioutil"
 )
@@ -18,40 +21,13 @@ func init() {
   
 }
 
-func find_mapping_for(maps map[ds.Range]*ds.MappedRegion, needle ds.Range) *ds.MappedRegion {
-	for rng, mapping := range maps {
-		if rng.IntersectsRange(needle) {
-			return mapping
-		}
-	}
-	return nil
-}
-
-func filter_empty_bbs(bbs map[uint64]ds.BB) map[uint64]ds.BB {
-	res := make(map[uint64]ds.BB)
-	for addr, bb := range bbs {
-		if !bb.Rng.IsEmpty() {
-			res[addr] = bb
-		}
-	}
-	return res
-}
-
-func extract_bbs(maps map[ds.Range]*ds.MappedRegion, rng ds.Range) map[uint64]ds.BB {
-	maped := find_mapping_for(maps, rng)
-	if maped == nil {
-		return nil
-	}
-	blocks := disassemble.GetBBs(maped.Range.From, maped.Data, rng)
-	return filter_empty_bbs(blocks)
-}
-
 func MakeBlanketEmulator(mem map[ds.Range]*ds.MappedRegion, env Environment) *Emulator {
 	config := Config{
 		MaxTraceInstructionCount: 100,
 		MaxTraceTime:             0,
 		MaxTracePages:            100,
 		Arch:                     &arch.ArchX86_64{},
+		Library:                  NewLibc(),
 	}
 	em := NewEmulator(mem, config, env)
 	return em
@@ -66,13 +42,13 @@ func ReadFull(t *testing.T, filename string) []byte {
     return data
 }
 
-func RunRawContent(t *testing.T, offset uint64, content []byte, env Environment,  maps map[ds.Range]*ds.MappedRegion, expected_bbs map[uint64]ds.BB, expected_events EventSet) {
+func RunRawContent(t *testing.T, offset uint64, content []byte, env Environment, expected_bbs map[uint64]ds.BB, expected_events EventSet) {
 	rng := ds.NewRange(offset, offset+uint64(len(content)))
-	maps[rng] = ds.NewMappedRegion([]byte(content), ds.R|ds.X, rng)
+	bin := loader.NewRawBinary(content, offset, &arch.ArchX86_64{})
 
-	emulator := MakeBlanketEmulator(maps, env)
+	emulator := MakeBlanketEmulator(bin.Segments, env)
 
-	bbs := extract_bbs(maps, rng)
+	bbs := bin.ExtractBBs(rng)
 
 	if !reflect.DeepEqual(bbs, expected_bbs) {
     fmt.Printf("disassembly failure, Should be:\n%#v\nIs       :\n%#v\n", expected_bbs, bbs)
@@ -98,16 +74,16 @@ func RunOnSingleBB(t* testing.T, filename string, env Environment, expected_even
   content :=  ReadFull(t, filename)
 
 	base := uint64(0x40000)
-  expected_bbs := map[uint64]ds.BB{base: *ds.NewBB(base, base+uint64(len(content)), []uint64{}) }
+  expected_bbs := map[uint64]ds.BB{base: *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN}) }
 
-  RunRawContent(t, base, content, env, make(map[ds.Range]*ds.MappedRegion), expected_bbs, expected_events)
+  RunRawContent(t, base, content, env, expected_bbs, expected_events)
 }
 
 func TestOneInstruction(t *testing.T) {
   filename := "../samples/simple/one_instr"
   env := NewRandEnv(0)
 
-  rax := env.GetReg(1)
+  rax := env.GetReg(6) //behind the six argument registers
   mem := binary.LittleEndian.Uint64( env.GetMem(rax,8) )
   expected_events:= EventSet{ReadEvent(rax):true, ReturnEvent(mem):true}
   RunOnSingleBB(t, filename, env, expected_events)
@@ -117,11 +93,11 @@ func TestRun(t *testing.T) {
   filename :=  "../samples/simple/one_bb"
   env := NewRandEnv(0)
 
-  rax := env.GetReg(1)
+  rax := env.GetReg(6)
   mem1 := binary.LittleEndian.Uint64( env.GetMem(rax,8) )
   mem2 := binary.LittleEndian.Uint64( env.GetMem(mem1,8) )
   mem3 := binary.LittleEndian.Uint64( env.GetMem(mem2,8) )
-  rbx := env.GetReg(2)
+  rbx := env.GetReg(9)
   expected_events:= EventSet{ReadEvent(rax):true, ReadEvent(mem1):true, ReadEvent(mem2):true, WriteEvent{Addr: rbx, Value: mem3}:true, ReturnEvent(mem3):true}
   RunOnSingleBB(t, filename, env, expected_events)
 }
@@ -140,14 +116,427 @@ func TestTreeCover(t *testing.T){
   content :=  ReadFull(t, "../samples/simple/tree_cover")
 
 	base := uint64(0x40000)
-  bb1 := *ds.NewBB(base, base+0x07, []uint64{base+0x0b, base+0x07})
-  bb2 := *ds.NewBB(base+0x07, base+0x0b, []uint64{})
-  bb3 := *ds.NewBB(base+0x0b, base+0x0f, []uint64{})
+  bb1 := *ds.NewBBWithEdges(base, base+0x07, ds.Edge{Kind: ds.CONDITIONAL, Target: base+0x0b}, ds.Edge{Kind: ds.FALLTHROUGH, Target: base+0x07})
+  bb2 := *ds.NewBBWithEdges(base+0x07, base+0x0b, ds.Edge{Kind: ds.RETURN})
+  bb3 := *ds.NewBBWithEdges(base+0x0b, base+0x0f, ds.Edge{Kind: ds.RETURN})
   expected_bbs := map[uint64]ds.BB{ bb1.Rng.From: bb1, bb2.Rng.From: bb2, bb3.Rng.From: bb3 }
 
   expected_events:= EventSet{ReturnEvent(99):true, ReturnEvent(101):true}
 
   env := NewRandEnv(0)
 
-  RunRawContent(t, base, content, env, make(map[ds.Range]*ds.MappedRegion), expected_bbs, expected_events)
+  RunRawContent(t, base, content, env, expected_bbs, expected_events)
+}
+
+func CallImport(prefix []byte, name string, suffix []byte) []byte {
+  target := make([]byte, 8)
+  binary.LittleEndian.PutUint64(target, ds.ImportAddress(name, 8))
+  content := append(prefix, 0x48, 0xb8) //mov rax, imm64
+  content = append(content, target...)
+  content = append(content, 0xff, 0xd0) //call rax
+  return append(content, suffix...)
+}
+
+func TestLibraryCall(t *testing.T){
+  //mov rdi, rbx; mov esi, 0x41; mov edx, 8; call memset; mov rax, [rbx]; ret
+  content := CallImport([]byte("\x48\x89\xdf\xbe\x41\x00\x00\x00\xba\x08\x00\x00\x00"), "memset", []byte("\x48\x8b\x03\xc3"))
+
+	base := uint64(0x40000)
+  bb1 := *ds.NewBBWithEdges(base, base+0x19, ds.Edge{Kind: ds.CALL}, ds.Edge{Kind: ds.FALLTHROUGH, Target: base+0x19})
+  bb2 := *ds.NewBBWithEdges(base+0x19, base+0x1d, ds.Edge{Kind: ds.RETURN})
+  expected_bbs := map[uint64]ds.BB{ bb1.Rng.From: bb1, bb2.Rng.From: bb2 }
+
+  env := NewRandEnv(0)
+  rbx := env.GetReg(9)
+  memset := CallEvent{Name: "memset", Args: [max_call_event_args]uint64{rbx, 0x41, 8}}
+  expected_events:= EventSet{memset:true, ReadEvent(rbx):true, ReturnEvent(0x4141414141414141):true}
+
+  RunRawContent(t, base, content, env, expected_bbs, expected_events)
+}
+
+func TestAArch64LibraryCall(t *testing.T){
+  //mov x20, x30; mov x19, x0; mov w1, 0x41; mov x2, 8; mov x16, memset (movz, 3*movk); blr x16; ldr x0, [x19]; ret x20
+  memset := ds.ImportAddress("memset", 8)
+  words := []uint32{0xaa1e03f4, 0xaa0003f3, 0x52800821, 0xd2800102}
+  for i, movk := range []uint32{0xd2800010, 0xf2a00010, 0xf2c00010, 0xf2e00010} {
+    words = append(words, movk | uint32((memset>>(16*uint(i)))&0xffff)<<5)
+  }
+  words = append(words, 0xd63f0200, 0xf9400260, 0xd65f0280)
+  content := make([]byte, 4*len(words))
+  for i, word := range words {
+    binary.LittleEndian.PutUint32(content[4*i:], word)
+  }
+
+	base := uint64(0x40000)
+  bb1 := *ds.NewBBWithEdges(base, base+0x24, ds.Edge{Kind: ds.CALL}, ds.Edge{Kind: ds.FALLTHROUGH, Target: base+0x24})
+  bb2 := *ds.NewBBWithEdges(base+0x24, base+0x2c, ds.Edge{Kind: ds.RETURN})
+  expected_bbs := map[uint64]ds.BB{ bb1.Rng.From: bb1, bb2.Rng.From: bb2 }
+
+  env := NewRandEnv(0)
+  bin := loader.NewRawBinary(content, base, &arch.ArchAArch64{})
+  bbs := bin.ExtractBBs(ds.NewRange(base, base+uint64(len(content))))
+	if !reflect.DeepEqual(bbs, expected_bbs) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", bbs, expected_bbs)
+    t.Fail()
+	}
+
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Arch = bin.Arch
+  if err := emulator.FullBlanket(bbs); err != nil {
+    t.Fatal(err)
+  }
+  //the stub page has to return with ret, x86 ret bytes are undefined instructions
+  x0 := env.GetReg(0)
+  call := CallEvent{Name: "memset", Args: [max_call_event_args]uint64{x0, 0x41, 8}}
+  expected_events := EventSet{call:true, ReadEvent(x0):true, ReturnEvent(0x4141414141414141):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+func TestHeap(t *testing.T){
+  //mov edi, 16; call malloc; mov [rax+8], rbx; ret
+  content := CallImport([]byte("\xbf\x10\x00\x00\x00"), "malloc", []byte("\x48\x89\x58\x08\xc3"))
+
+	base := uint64(0x40000)
+  bb1 := *ds.NewBBWithEdges(base, base+0x11, ds.Edge{Kind: ds.CALL}, ds.Edge{Kind: ds.FALLTHROUGH, Target: base+0x11})
+  bb2 := *ds.NewBBWithEdges(base+0x11, base+0x16, ds.Edge{Kind: ds.RETURN})
+  expected_bbs := map[uint64]ds.BB{ bb1.Rng.From: bb1, bb2.Rng.From: bb2 }
+
+  env := NewRandEnv(0)
+  rbx := env.GetReg(9)
+  malloc := CallEvent{Name: "malloc", Args: [max_call_event_args]uint64{16, 0, 0}}
+  expected_events:= EventSet{malloc:true, WriteEvent{Addr: heap_id_base+8, Value: rbx}:true, ReturnEvent(heap_id_base):true}
+
+  RunRawContent(t, base, content, env, expected_bbs, expected_events)
+}
+
+func TestSyscall(t *testing.T){
+  //mov eax, 1; mov edi, 1; mov rsi, rbx; mov edx, 5; syscall; mov eax, 4; mov ebx, 2; xor ecx, ecx; xor edx, edx; int 0x80; ret
+  content := []byte("\xb8\x01\x00\x00\x00\xbf\x01\x00\x00\x00\x48\x89\xde\xba\x05\x00\x00\x00\x0f\x05\xb8\x04\x00\x00\x00\xbb\x02\x00\x00\x00\x31\xc9\x31\xd2\xcd\x80\xc3")
+
+	base := uint64(0x40000)
+  expected_bbs := map[uint64]ds.BB{base: *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})}
+
+  env := NewRandEnv(0)
+  rbx := env.GetReg(9)
+  write := SyscallEvent{Number: 1, Args: [max_syscall_event_args]uint64{1, rbx, 5}}
+  write32 := SyscallEvent{Number: 4, Args: [max_syscall_event_args]uint64{2, 0, 0}}
+  expected_events:= EventSet{write:true, write32:true, ReturnEvent(syscall_result(4)):true}
+
+  RunRawContent(t, base, content, env, expected_bbs, expected_events)
+}
+
+type FixedFrame struct {
+  cfa_offset int64
+  size uint64
+}
+
+func (f FixedFrame) CFA(addr uint64) (int, int64, bool) { return uc.X86_REG_RSP, f.cfa_offset, true }
+func (f FixedFrame) Size() (uint64, bool) { return f.size, true }
+
+func TestFrameInfo(t *testing.T){
+  //sub rsp, 0x1000; mov [rsp+0x800], rbx; add rsp, 0x1000; ret
+  content := []byte("\x48\x81\xec\x00\x10\x00\x00\x48\x89\x9c\x24\x00\x08\x00\x00\x48\x81\xc4\x00\x10\x00\x00\xc3")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBB(base, base+uint64(len(content)), []uint64{})
+  env := NewRandEnv(0)
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchX86_64{})
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Frame = FixedFrame{cfa_offset: 8, size: 0x1008}
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  //the local variable is far above the stack pointer, but within the frame
+  expected_events := EventSet{ReturnEvent(env.GetReg(6)):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
 }
+
+func TestI386(t *testing.T){
+  //mov eax, [eax]; mov [ebx], eax; ret
+  content := []byte("\x8b\x00\x89\x03\xc3")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBB(base, base+uint64(len(content)), []uint64{})
+  env := NewRandEnv(0)
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchX86{})
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Arch = bin.Arch
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  //registers and pointers are 4 bytes wide, no arguments are passed in registers
+  eax := uint64(uint32(env.GetReg(0)))
+  ebx := uint64(uint32(env.GetReg(3)))
+  mem := uint64(binary.LittleEndian.Uint32( env.GetMem(eax,4) ))
+  expected_events := EventSet{ReadEvent(eax):true, WriteEvent{Addr: ebx, Value: mem}:true, ReturnEvent(mem):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+//f calls imported through its PLT stub, the GOT slot holds a 4 byte import address
+func TestI386PLTCall(t *testing.T){
+  bin, err := loader.Open("../samples/i386/shared")
+  if err != nil {
+    t.Fatal(err)
+  }
+  var bbs map[uint64]ds.BB
+  for rng, symb := range bin.Symbols {
+    if symb.Name == "f" {
+      bbs = bin.ExtractBBs(rng)
+    }
+  }
+
+  emulator := MakeBlanketEmulator(bin.Segments, NewRandEnv(0))
+  emulator.Config.Arch = bin.Arch
+  emulator.Config.Imports = bin.ImportTargets()
+  if err := emulator.FullBlanket(bbs); err != nil {
+    t.Fatal(err)
+  }
+  calls := make([]string, 0)
+  for ev := range *emulator.Events {
+    if call, ok := ev.(CallEvent); ok {
+      calls = append(calls, call.Name)
+    }
+  }
+	if !reflect.DeepEqual(calls, []string{"imported"}) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", calls, []string{"imported"})
+    t.Fail()
+	}
+}
+
+func TestMIPSDelaySlot(t *testing.T){
+  //jr $ra; lw $v0, 0($a0) (big endian)
+  content := []byte("\x03\xe0\x00\x08\x8c\x82\x00\x00")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})
+  env := NewRandEnv(0)
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchMIPS{})
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Arch = bin.Arch
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  //the load in the delay slot sets the return value
+  a0 := uint64(uint32(env.GetReg(0)))
+  mem := uint64(binary.BigEndian.Uint32( env.GetMem(a0,4) ))
+  expected_events := EventSet{ReadEvent(a0):true, ReturnEvent(mem):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+func TestMIPSFunctionAddress(t *testing.T){
+  //position independent code finds its GOT through $t9: move $v0, $t9; jr $ra; nop (big endian)
+  content := []byte("\x03\x20\x10\x21\x03\xe0\x00\x08\x00\x00\x00\x00")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchMIPS{})
+  emulator := MakeBlanketEmulator(bin.Segments, NewRandEnv(0))
+  emulator.Config.Arch = bin.Arch
+  emulator.Config.Function = base
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  expected_events := EventSet{ReturnEvent(base):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+func TestRISCV64GlobalPointer(t *testing.T){
+  //mv a0, gp; ret (c.jr ra)
+  content := []byte("\x13\x85\x01\x00\x82\x80")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchRISCV64{})
+  for gp, expected := range map[uint64]uint64{0: 0, base+0x800: base+0x800} {
+    emulator := MakeBlanketEmulator(bin.Segments, NewRandEnv(0))
+    emulator.Config.Arch = bin.Arch
+    emulator.Config.GlobalPointer = gp
+    if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+      t.Fatal(err)
+    }
+    //without __global_pointer$ gp is left alone instead of seeded from the Environment
+    expected_events := EventSet{ReturnEvent(expected):true}
+    if !reflect.DeepEqual(emulator.Events, &expected_events) {
+      fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+      t.Fail()
+    }
+  }
+}
+
+func TestRISCV64Syscall(t *testing.T){
+  //li a7, 64; ecall; ret (c.jr ra)
+  content := []byte("\x93\x08\x00\x04\x73\x00\x00\x00\x82\x80")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})
+  env := NewRandEnv(0)
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchRISCV64{})
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Arch = bin.Arch
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  //the arguments are in a0-a2, the result replaces a0
+  write := SyscallEvent{Number: 64, Args: [max_syscall_event_args]uint64{env.GetReg(0), env.GetReg(1), env.GetReg(2)}}
+  expected_events := EventSet{write:true, ReturnEvent(syscall_result(64)):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+func TestCallingConventions(t *testing.T){
+  //the first argument is dereferenced and returned: mov rax, [rdi]; ret and mov rax, [rcx]; ret
+  sysv := []byte("\x48\x8b\x07\xc3")
+  win64 := []byte("\x48\x8b\x01\xc3")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+4, ds.Edge{Kind: ds.RETURN})
+  env := NewRandEnv(0)
+
+  events := make([]*EventSet, 0)
+  for content, architecture := range map[string]*arch.ArchX86_64{string(sysv): {}, string(win64): {Win64: true}} {
+    bin := loader.NewRawBinary([]byte(content), base, architecture)
+    emulator := MakeBlanketEmulator(bin.Segments, env)
+    emulator.Config.Arch = bin.Arch
+    if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+      t.Fatal(err)
+    }
+    events = append(events, emulator.Events)
+  }
+  //both start with the same first argument
+  arg := env.GetReg(0)
+  mem := binary.LittleEndian.Uint64( env.GetMem(arg,8) )
+  expected_events := EventSet{ReadEvent(arg):true, ReturnEvent(mem):true}
+  for _, ev := range events {
+    if !reflect.DeepEqual(ev, &expected_events) {
+      fmt.Printf("Is: %#v\nSh: %#v\n", *ev, expected_events)
+      t.Fail()
+    }
+  }
+}
+
+func TestRetImm(t *testing.T){
+  //ret imm16 is a return like ret: mov rax, [rdi]; ret 8
+  content := []byte("\x48\x8b\x07\xc2\x08\x00")
+
+	base := uint64(0x40000)
+  bb := *ds.NewBBWithEdges(base, base+uint64(len(content)), ds.Edge{Kind: ds.RETURN})
+  env := NewRandEnv(0)
+
+  bin := loader.NewRawBinary(content, base, &arch.ArchX86_64{})
+  emulator := MakeBlanketEmulator(bin.Segments, env)
+  emulator.Config.Arch = bin.Arch
+  if err := emulator.FullBlanket(map[uint64]ds.BB{base: bb}); err != nil {
+    t.Fatal(err)
+  }
+  rdi := env.GetReg(0)
+  mem := binary.LittleEndian.Uint64( env.GetMem(rdi,8) )
+  expected_events := EventSet{ReadEvent(rdi):true, ReturnEvent(mem):true}
+	if !reflect.DeepEqual(emulator.Events, &expected_events) {
+		fmt.Printf("Is: %#v\nSh: %#v\n", *emulator.Events, expected_events)
+    t.Fail()
+	}
+}
+
+func TestSnapshot(t *testing.T){
+  //memset overwrites data of the image, the later trace has to find it unchanged again:
+  //lea rdi, [rip+0xf9]; mov esi, 0x41; mov edx, 8; call memset; ret and mov rax, [rip+0xdb]; ret
+  content := CallImport([]byte("\x48\x8d\x3d\xf9\x00\x00\x00\xbe\x41\x00\x00\x00\xba\x08\x00\x00\x00"), "memset", []byte("\xc3\x48\x8b\x05\xdb\x00\x00\x00\xc3"))
+  content = append(content, make([]byte, 0x100-len(content))...)
+  content = append(content, bytes.Repeat([]byte{0x11}, 8)...)
+
+	base := uint64(0x40000)
+  bb1 := *ds.NewBBWithEdges(base, base+0x1d, ds.Edge{Kind: ds.CALL}, ds.Edge{Kind: ds.FALLTHROUGH, Target: base+0x1d})
+  bb2 := *ds.NewBBWithEdges(base+0x1d, base+0x1e, ds.Edge{Kind: ds.RETURN})
+  bb3 := *ds.NewBBWithEdges(base+0x1e, base+0x26, ds.Edge{Kind: ds.RETURN})
+  bbs := map[uint64]ds.BB{ bb1.Rng.From: bb1, bb2.Rng.From: bb2, bb3.Rng.From: bb3 }
+
+  //a new engine per trace and one engine restored to its snapshot see the same
+  events := make([]*EventSet, 0)
+  for _, fresh := range []bool{true, false} {
+    bin := loader.NewRawBinary(content, base, &arch.ArchX86_64{})
+    emulator := MakeBlanketEmulator(bin.Segments, NewRandEnv(0))
+    emulator.Config.FreshEngine = fresh
+    if err := emulator.FullBlanket(bbs); err != nil {
+      t.Fatal(err)
+    }
+    emulator.Close()
+    events = append(events, emulator.Events)
+  }
+  if !reflect.DeepEqual(events[0], events[1]) || !(*events[1])[ReturnEvent(0x1111111111111111)] {
+    fmt.Printf("Is: %#v\nSh: %#v\n", *events[1], *events[0])
+    t.Fail()
+  }
+}
+
+type benchmark_function struct {
+  arch arch.Arch
+  bbs map[uint64]ds.BB
+}
+
+//emulates the first functions of readelf, with a new engine per trace or with one engine per function
+func benchmarkFullBlanket(b *testing.B, fresh bool) {
+  log.SetLevel(log.ErrorLevel)
+  defer log.SetLevel(log.DebugLevel)
+  bin, err := loader.Open("../samples/binutils/bin_O2/readelf")
+  if err != nil {
+    b.Fatal(err)
+  }
+  ranges := make([]ds.Range, 0)
+  for rng, symb := range bin.Symbols {
+    if symb.Type == ds.FUNC {
+      ranges = append(ranges, rng)
+    }
+  }
+  sort.Slice(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+  functions := make([]benchmark_function, 0)
+  for _, rng := range ranges {
+    if bbs := bin.ExtractBBs(rng); len(bbs) > 0 && len(functions) < 20 {
+      functions = append(functions, benchmark_function{bin.ArchAt(rng.From), bbs})
+    }
+  }
+
+  imports := bin.ImportTargets()
+
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    for _, function := range functions {
+      config := Config{
+        MaxTraceInstructionCount: 100,
+        MaxTracePages:            50,
+        Arch:                     function.arch,
+        Library:                  NewLibc(),
+        Imports:                  imports,
+        FreshEngine:              fresh,
+      }
+      emulator := NewEmulator(bin.Segments, config, NewRandEnv(0))
+      emulator.FullBlanket(function.bbs)
+      emulator.Close()
+    }
+  }
+}
+
+func BenchmarkFreshEngine(b *testing.B) { benchmarkFullBlanket(b, true) }
+func BenchmarkSnapshot(b *testing.B) { benchmarkFullBlanket(b, false) }
@@ -7,8 +7,10 @@ import (
 const invalid_salt = uint64(0xe629c416d6207e3f)
 const return_salt = uint64(0xaac5349f49795c84)
 const sys_salt = uint64(0xc07aabb52435b174)
+const sys_ret_salt = uint64(0x2f6d81c9b04e7a35)
 const read_salt = uint64(0xf7921a7ed5b6e400)
 const write_salt = uint64(0x4768ff659301e8b7)
+const call_salt = uint64(0x3b9d0c5e81f4a627)
 
 const order_salt = uint64(0x6e53469168745d93)
 const final_salt = uint64(0x12ef5c82f29260c5)
@@ -36,17 +38,25 @@ func WriteEventHash(addr uint64, value uint64) uint64 {
 	return fast_hash(fast_hash(write_salt, addr), value)
 }
 
-func SysEventHash(syscallnum uint64) uint64 {
-	return fast_hash(sys_salt, syscallnum)
+func SysEventHash(syscallnum uint64, args []uint64) uint64 {
+	res := fast_hash(sys_salt, syscallnum)
+	for _, arg := range args {
+		res = fast_hash(res, arg)
+	}
+	return res
 }
 
 func ReturnEventHash(value uint64) uint64 {
 	return fast_hash(return_salt, value)
 }
 
-//func CallEventHash(arg1 uint64, arg2 uint64) uint64 {
-//	return fast_hash(fast_hash(initial_salt, arg1), arg2)
-//}
+func CallEventHash(name string, args []uint64) uint64 {
+	res := xxhash.Checksum64S([]byte(name), call_salt)
+	for _, arg := range args {
+		res = fast_hash(res, arg)
+	}
+	return res
+}
 
 func InvalidInstructionEventHash(arg1 uint64) uint64 {
 	return fast_hash(invalid_salt, arg1)
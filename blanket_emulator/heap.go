@@ -0,0 +1,101 @@
+package blanket_emulator
+
+import (
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// intercepted allocations are served from this region, it is not used by the binary, the imports or (most likely) the
+// Environment
+const heap_base = uint64(0x7f1e00000000)
+const heap_size = uint64(0x100000000)
+
+// the heap of 32 bit code has to be addressable by 4 byte pointers
+const heap_base_32 = uint64(0xd1e00000)
+const heap_size_32 = uint64(0x10000000)
+
+//heap pointers are replaced by heap_id_base + allocation index<<32 + offset into the allocation
+const heap_id_base = uint64(0x4ea9000000000000)
+
+// Heap is a deterministic bump allocator. Every allocation starts on a fresh page and is followed by an unused guard
+// page, so that pointers slightly beyond the end of an allocation are still attributed to it.
+type Heap struct {
+	allocations []ds.Range
+	zeroed      map[int]bool
+	base        uint64
+	size        uint64
+	next        uint64
+}
+
+// NewHeap creates an empty heap for code with pointers of pointer_size bytes
+func NewHeap(pointer_size int) *Heap {
+	base, size := heap_base, heap_size
+	if pointer_size == 4 {
+		base, size = heap_base_32, heap_size_32
+	}
+	return &Heap{allocations: make([]ds.Range, 0), zeroed: make(map[int]bool), base: base, size: size, next: base}
+}
+
+// Alloc returns the address of a new allocation, or 0 if size is unreasonable. Memory of zeroed allocations reads as
+// zero, memory of other allocations is filled from the Environment like any other page.
+func (h *Heap) Alloc(size uint64, zeroed bool) uint64 {
+	if size > max_allocation_size {
+		return 0
+	}
+	if size == 0 {
+		size = 1
+	}
+	end := h.next + size
+	if end > h.base+h.size {
+		return 0
+	}
+	addr := h.next
+	h.zeroed[len(h.allocations)] = zeroed
+	h.allocations = append(h.allocations, ds.NewRange(addr, end))
+	h.next = end - end%pagesize + 2*pagesize
+	return addr
+}
+
+func (h *Heap) Contains(addr uint64) bool {
+	return h.base <= addr && addr < h.next
+}
+
+// Resolve returns the index of the allocation containing addr and the offset into it
+func (h *Heap) Resolve(addr uint64) (int, uint64, bool) {
+	if !h.Contains(addr) {
+		return 0, 0, false
+	}
+	for i := len(h.allocations) - 1; i >= 0; i-- {
+		if h.allocations[i].From <= addr {
+			return i, addr - h.allocations[i].From, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Size returns the size of the allocation starting at addr
+func (h *Heap) Size(addr uint64) (uint64, bool) {
+	index, offset, ok := h.Resolve(addr)
+	if !ok || offset != 0 {
+		return 0, false
+	}
+	return h.allocations[index].Length(), true
+}
+
+// IsZeroed is true if the page starting at addr belongs to a zeroed allocation (pages are never shared)
+func (h *Heap) IsZeroed(page uint64) bool {
+	index, _, ok := h.Resolve(page)
+	return ok && h.zeroed[index] && page < h.allocations[index].To
+}
+
+func (s *Emulator) resolve_heap(addr uint64) uint64 {
+	index, offset, ok := s.Heap.Resolve(addr)
+	if !ok {
+		return addr
+	}
+	return heap_id_base + uint64(index)<<32 + offset
+}
+
+//replaces static and heap addresses by identifiers that do not depend on the memory layout
+func (s *Emulator) resolve_address(addr uint64) uint64 {
+	return s.resolve_heap(s.resolve_static(addr))
+}
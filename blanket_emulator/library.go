@@ -0,0 +1,301 @@
+package blanket_emulator
+
+import (
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+// the number of arguments recorded in a CallEvent
+const max_call_event_args = 3
+
+// strings and buffers are never touched beyond this length, so that garbage from the Environment can't stall a trace
+const max_library_buffer_size = pagesize
+
+//allocations larger than this fail (return NULL), sizes are often garbage from the Environment
+const max_allocation_size = uint64(0x1000000)
+
+// the number of arguments passed to the simulation of a library function
+const max_library_args = 6
+
+// arguments reads the first n integer arguments of the function that was just called. Those the calling convention
+// does not pass in registers are read from the stack, above the return address and the home space.
+func (s *Emulator) arguments(n int) []uint64 {
+	abi := s.Config.Arch.GetABI()
+	size := uint64(s.Config.Arch.GetPointerSize())
+	stack, _ := s.mu.RegRead(s.Config.Arch.GetRegStack())
+	res := make([]uint64, n)
+	for i := range res {
+		if i < len(abi.Arguments) {
+			res[i], _ = s.mu.RegRead(abi.Arguments[i])
+			continue
+		}
+		addr := stack + abi.ReturnAddressSize + abi.HomeSpace + uint64(i-len(abi.Arguments))*size
+		mem, err := s.ReadMemory(addr, size)
+		if err != nil {
+			continue
+		}
+		if size == 4 {
+			res[i] = uint64(s.Config.Arch.GetByteOrder().Uint32(mem))
+		} else {
+			res[i] = s.Config.Arch.GetByteOrder().Uint64(mem)
+		}
+	}
+	return res
+}
+
+// stub_instruction returns to the caller, it fills the pages of fake import addresses
+func (s *Emulator) stub_instruction() []byte {
+	words := func(ins ...uint32) []byte {
+		res := make([]byte, 4*len(ins))
+		for i, word := range ins {
+			s.Config.Arch.GetByteOrder().PutUint32(res[4*i:], word)
+		}
+		return res
+	}
+	switch s.Config.Arch.ToUnicornArchDescription() {
+	case uc.ARCH_RISCV:
+		return []byte{0x82, 0x80} //c.jr ra
+	case uc.ARCH_ARM64:
+		return words(0xd65f03c0) //ret
+	case uc.ARCH_ARM:
+		return words(0xe12fff1e) //bx lr
+	case uc.ARCH_MIPS:
+		return words(0x03e00008, 0) //jr ra; nop in the delay slot
+	case uc.ARCH_PPC:
+		return words(0x4e800020) //blr
+	}
+	return []byte{0xc3}
+}
+
+// LibraryFunction simulates an imported function. Run gets the raw arguments and returns the value placed in the return
+// register. Only the first Args arguments end up in the CallEvent.
+type LibraryFunction struct {
+	Args     int
+	NoReturn bool
+	Run      func(em *Emulator, args []uint64) uint64
+}
+
+// Library maps import names to their simulation. Imports not contained in the Library still produce a CallEvent
+// and return a value that only depends on their name.
+type Library map[string]*LibraryFunction
+
+// NameOf finds the simulated function whose fake import address in code with pointers of pointer_size bytes is addr,
+// so that raw code can call into the Library without an import table
+func (lib Library) NameOf(addr uint64, pointer_size int) string {
+	for name := range lib {
+		if ds.ImportAddress(name, pointer_size) == addr {
+			return name
+		}
+	}
+	return ""
+}
+
+func returns(val uint64) func(*Emulator, []uint64) uint64 {
+	return func(em *Emulator, args []uint64) uint64 { return val }
+}
+
+func returnsArg(index int) func(*Emulator, []uint64) uint64 {
+	return func(em *Emulator, args []uint64) uint64 { return args[index] }
+}
+
+func NewLibc() Library {
+	lib := Library{
+		"malloc":           {Args: 1, Run: libMalloc},
+		"calloc":           {Args: 2, Run: libCalloc},
+		"realloc":          {Args: 2, Run: libRealloc},
+		"free":             {Args: 1, Run: returns(0)},
+		"memcpy":           {Args: 3, Run: libMemcpy},
+		"memmove":          {Args: 3, Run: libMemcpy},
+		"memset":           {Args: 3, Run: libMemset},
+		"strlen":           {Args: 1, Run: libStrlen},
+		"strcmp":           {Args: 2, Run: libStrcmp},
+		"printf":           {Args: 1, Run: libPrintf},
+		"puts":             {Args: 1, Run: libPrintf},
+		"fprintf":          {Args: 2, Run: returns(0)},
+		"sprintf":          {Args: 2, Run: libSprintf},
+		"snprintf":         {Args: 3, Run: libSnprintf},
+		"putchar":          {Args: 1, Run: returnsArg(0)},
+		"exit":             {Args: 1, NoReturn: true, Run: returns(0)},
+		"_exit":            {Args: 1, NoReturn: true, Run: returns(0)},
+		"abort":            {Args: 0, NoReturn: true, Run: returns(0)},
+		"__stack_chk_fail": {Args: 0, NoReturn: true, Run: returns(0)},
+		"__assert_fail":    {Args: 0, NoReturn: true, Run: returns(0)},
+	}
+	lib["__memcpy_chk"] = lib["memcpy"]
+	lib["__memset_chk"] = lib["memset"]
+	lib["__printf_chk"] = &LibraryFunction{Args: 2, Run: libPrintfChk}
+	lib["__sprintf_chk"] = &LibraryFunction{Args: 2, Run: libSprintf}
+	return lib
+}
+
+func (s *Emulator) readBuffer(addr, size uint64) []byte {
+	if size > max_library_buffer_size {
+		size = max_library_buffer_size
+	}
+	if size == 0 {
+		return []byte{}
+	}
+	mem, err := s.ReadMemory(addr, size)
+	if err != nil {
+		log.WithFields(log.Fields{"addr": hex(addr), "size": size, "error": err}).Debug("Library failed to read")
+		return []byte{}
+	}
+	return mem
+}
+
+func (s *Emulator) writeBuffer(addr uint64, data []byte) {
+	if len(data) > max_library_buffer_size {
+		data = data[:max_library_buffer_size]
+	}
+	if len(data) == 0 {
+		return
+	}
+	//make sure the pages are mapped (and filled from the Env) before overwriting them
+	if _, err := s.ReadMemory(addr, uint64(len(data))); err != nil {
+		log.WithFields(log.Fields{"addr": hex(addr), "size": len(data), "error": err}).Debug("Library failed to map")
+		return
+	}
+	s.preserve(addr, uint64(len(data)))
+	if err := s.mu.MemWrite(addr, data); err != nil {
+		log.WithFields(log.Fields{"addr": hex(addr), "size": len(data), "error": err}).Debug("Library failed to write")
+	}
+}
+
+func (s *Emulator) readString(addr uint64) []byte {
+	res := make([]byte, 0)
+	for i := uint64(0); i < max_library_buffer_size; i++ {
+		c := s.readBuffer(addr+i, 1)
+		if len(c) == 0 || c[0] == 0 {
+			break
+		}
+		res = append(res, c[0])
+	}
+	return res
+}
+
+func libMalloc(em *Emulator, args []uint64) uint64 {
+	return em.Heap.Alloc(args[0], false)
+}
+
+func libCalloc(em *Emulator, args []uint64) uint64 {
+	if args[1] != 0 && args[0] > max_allocation_size/args[1] {
+		return 0
+	}
+	return em.Heap.Alloc(args[0]*args[1], true)
+}
+
+func libRealloc(em *Emulator, args []uint64) uint64 {
+	ptr := em.Heap.Alloc(args[1], false)
+	if ptr == 0 {
+		return 0
+	}
+	size := args[1]
+	if old_size, ok := em.Heap.Size(args[0]); ok && old_size < size {
+		size = old_size
+	}
+	em.writeBuffer(ptr, em.readBuffer(args[0], size))
+	return ptr
+}
+
+func libMemcpy(em *Emulator, args []uint64) uint64 {
+	em.writeBuffer(args[0], em.readBuffer(args[1], args[2]))
+	return args[0]
+}
+
+func libMemset(em *Emulator, args []uint64) uint64 {
+	size := args[2]
+	if size > max_library_buffer_size {
+		size = max_library_buffer_size
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(args[1])
+	}
+	em.writeBuffer(args[0], data)
+	return args[0]
+}
+
+func libStrlen(em *Emulator, args []uint64) uint64 {
+	return uint64(len(em.readString(args[0])))
+}
+
+func libStrcmp(em *Emulator, args []uint64) uint64 {
+	a := em.readString(args[0])
+	b := em.readString(args[1])
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return uint64(int64(a[i]) - int64(b[i]))
+		}
+	}
+	return uint64(int64(len(a)) - int64(len(b)))
+}
+
+// formatting is not simulated, the output is assumed to be as long as the format string
+func libPrintf(em *Emulator, args []uint64) uint64 {
+	return uint64(len(em.readString(args[0])))
+}
+
+func libPrintfChk(em *Emulator, args []uint64) uint64 {
+	return uint64(len(em.readString(args[1])))
+}
+
+func libSprintf(em *Emulator, args []uint64) uint64 {
+	em.writeBuffer(args[0], []byte{0})
+	return 0
+}
+
+func libSnprintf(em *Emulator, args []uint64) uint64 {
+	if args[1] > 0 {
+		em.writeBuffer(args[0], []byte{0})
+	}
+	return 0
+}
+
+func (s *Emulator) isLibraryCall(addr uint64) bool {
+	return s.Config.Library != nil && ds.IsImportAddress(addr, s.Config.Arch.GetPointerSize())
+}
+
+// mapLibraryStub backs the page of a fake import address with ret instructions, so that control returns to the caller
+// once OnLibraryCall simulated the function
+func (s *Emulator) mapLibraryStub(addr uint64) bool {
+	page := addr - addr%pagesize
+	if err := s.mu.MemMapProt(page, pagesize, uc.PROT_READ|uc.PROT_EXEC); err != nil {
+		log.WithFields(log.Fields{"addr": hex(addr), "error": err}).Error("Failed to map library stub")
+		return false
+	}
+	rets := make([]byte, pagesize)
+	ret := s.stub_instruction()
+	for i := range rets {
+		rets[i] = ret[i%len(ret)]
+	}
+	if err := s.mu.MemWrite(page, rets); err != nil {
+		log.WithFields(log.Fields{"addr": hex(addr), "error": err}).Error("Failed to write library stub")
+		return false
+	}
+	return true
+}
+
+func (s *Emulator) OnLibraryCall(addr uint64) {
+	name, ok := s.Config.Imports[addr]
+	if !ok {
+		name = s.Config.Library.NameOf(addr, s.Config.Arch.GetPointerSize())
+	}
+	args := s.arguments(max_library_args)
+
+	var event_args [max_call_event_args]uint64
+	ret := fast_hash(call_salt, addr)
+	fun, known := s.Config.Library[name]
+	if known {
+		for i := 0; i < fun.Args && i < max_call_event_args; i++ {
+			event_args[i] = s.resolve_address(args[i])
+		}
+		ret = fun.Run(s, args)
+	}
+	log.WithFields(log.Fields{"name": name, "args": event_args, "ret": hex(ret)}).Info("Call Event")
+	s.CallEvent(name, event_args)
+	s.mu.RegWrite(s.Config.Arch.GetABI().Ret, ret)
+	if known && fun.NoReturn {
+		s.mu.Stop()
+	}
+}
@@ -0,0 +1,88 @@
+package blanket_emulator
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-errors/errors"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+// snapshot is the state of the engine right after CreateUnicorn loaded the image. Traces start from it instead of from
+// a new engine: restoring it keeps the hooks and the image, only the pages the previous trace touched are redone.
+type snapshot struct {
+	context uc.Context
+	image   map[uint64]int    //pages of the image -> their protection
+	dirty   map[uint64][]byte //pages of the image the current trace changed -> their content in the snapshot
+}
+
+// take_snapshot records the registers and the mapped pages of the engine
+func (s *Emulator) take_snapshot() *errors.Error {
+	context, err := s.mu.ContextSave(nil)
+	if err != nil {
+		return wrap(err)
+	}
+	regions, err := s.mu.MemRegions()
+	if err != nil {
+		return wrap(err)
+	}
+	image := make(map[uint64]int)
+	for _, region := range regions {
+		for page := region.Begin; page < region.End; page += pagesize { //End is the last byte of the region
+			image[page] = region.Prot
+		}
+	}
+	s.snapshot = &snapshot{context: context, image: image, dirty: make(map[uint64][]byte)}
+	return nil
+}
+
+// preserve saves the image pages of [addr, addr+length) before they are remapped or written the first time in a
+// trace. The emulated code can't change them, they are mapped read only, but the library and State.Apply can.
+func (s *Emulator) preserve(addr, length uint64) {
+	if s.snapshot == nil {
+		return
+	}
+	first, last := s.PagesFor(addr, length)
+	for page := first; page < last; page += pagesize {
+		if _, ok := s.snapshot.image[page]; !ok || s.snapshot.dirty[page] != nil {
+			continue
+		}
+		mem, err := s.mu.MemRead(page, pagesize)
+		if err != nil {
+			log.WithFields(log.Fields{"page": hex(page), "error": err}).Error("Failed to preserve page")
+			continue
+		}
+		s.snapshot.dirty[page] = mem
+	}
+}
+
+// restore_snapshot brings the engine back to the snapshot: every page mapped during the trace is unmapped, the image
+// pages it changed are rewritten and the registers are restored
+func (s *Emulator) restore_snapshot() *errors.Error {
+	regions, err := s.mu.MemRegions()
+	if err != nil {
+		return wrap(err)
+	}
+	for _, region := range regions {
+		for page := region.Begin; page < region.End; page += pagesize {
+			if _, ok := s.snapshot.image[page]; ok {
+				continue
+			}
+			if err := s.mu.MemUnmap(page, pagesize); err != nil {
+				return wrap(err)
+			}
+		}
+	}
+	for page, mem := range s.snapshot.dirty {
+		s.mu.MemUnmap(page, pagesize) //the page may have been unmapped by MapPageForRange
+		if err := s.mu.MemMapProt(page, pagesize, uc.PROT_WRITE); err != nil {
+			return wrap(err)
+		}
+		if err := s.mu.MemWrite(page, mem); err != nil {
+			return wrap(err)
+		}
+		if err := s.mu.MemProtect(page, pagesize, s.snapshot.image[page]); err != nil {
+			return wrap(err)
+		}
+	}
+	s.snapshot.dirty = make(map[uint64][]byte)
+	return wrap(s.mu.ContextRestore(s.snapshot.context))
+}
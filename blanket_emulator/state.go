@@ -15,7 +15,8 @@ type State struct {
 }
 func ExtractState(em *Emulator) (*State,*errors.Error) {
   s := &State{ Regs: make(map[int]uint64), Stack: nil, StackAddr: 0 }
-  for _,reg := range em.Config.Arch.GetRegisters() {
+  registers := append(em.Config.Arch.GetABI().Registers(), em.Config.Arch.GetRegStack())
+  for _,reg := range registers {
     val, err := em.mu.RegRead(reg)
     if err != nil {
       return nil,wrap(err)
@@ -0,0 +1,86 @@
+package blanket_emulator
+
+import (
+	log "github.com/Sirupsen/logrus"
+	uc "github.com/unicorn-engine/unicorn/bindings/go/unicorn"
+)
+
+//the number of arguments recorded in a SyscallEvent
+const max_syscall_event_args = 3
+
+const linux_int_syscall = 0x80
+
+//the exceptions of ecall in user and machine mode, unicorn continues behind the ecall once the hook returns
+const riscv_ecall_user, riscv_ecall_machine = 8, 11
+
+type syscall_convention struct {
+	number int
+	args   []int
+	ret    int
+	exits  []uint64 //exit and exit_group never return
+}
+
+var syscall_x86_64 = syscall_convention{
+	number: uc.X86_REG_RAX,
+	args:   []int{uc.X86_REG_RDI, uc.X86_REG_RSI, uc.X86_REG_RDX, uc.X86_REG_R10, uc.X86_REG_R8, uc.X86_REG_R9},
+	ret:    uc.X86_REG_RAX,
+	exits:  []uint64{60, 231},
+}
+
+var syscall_i386 = syscall_convention{
+	number: uc.X86_REG_EAX,
+	args:   []int{uc.X86_REG_EBX, uc.X86_REG_ECX, uc.X86_REG_EDX, uc.X86_REG_ESI, uc.X86_REG_EDI, uc.X86_REG_EBP},
+	ret:    uc.X86_REG_EAX,
+	exits:  []uint64{1, 252},
+}
+
+var syscall_riscv64 = syscall_convention{
+	number: uc.RISCV_REG_A7,
+	args:   []int{uc.RISCV_REG_A0, uc.RISCV_REG_A1, uc.RISCV_REG_A2, uc.RISCV_REG_A3, uc.RISCV_REG_A4, uc.RISCV_REG_A5},
+	ret:    uc.RISCV_REG_A0,
+	exits:  []uint64{93, 94},
+}
+
+// the result only depends on the syscall number, it is small and positive so that the error handling paths of the
+// wrappers are not taken
+func syscall_result(number uint64) uint64 {
+	return fast_hash(sys_ret_salt, number) & 0xff
+}
+
+func (s *Emulator) OnSyscall(conv syscall_convention) {
+	number, _ := s.mu.RegRead(conv.number)
+	var args [max_syscall_event_args]uint64
+	for i := range args {
+		val, _ := s.mu.RegRead(conv.args[i])
+		args[i] = s.resolve_address(val)
+	}
+	ret := syscall_result(number)
+
+	log.WithFields(log.Fields{"num": number, "args": args, "ret": hex(ret)}).Info("Syscall Event")
+	s.SyscallEvent(number, args)
+	s.mu.RegWrite(conv.ret, ret)
+	for _, exit := range conv.exits {
+		if number == exit {
+			s.mu.Stop()
+		}
+	}
+}
+
+func (s *Emulator) OnInterrupt(intno uint32) {
+	switch s.Config.Arch.ToUnicornArchDescription() {
+	case uc.ARCH_X86:
+		if intno == linux_int_syscall {
+			s.OnSyscall(syscall_i386)
+			return
+		}
+	case uc.ARCH_RISCV:
+		if intno == riscv_ecall_user || intno == riscv_ecall_machine {
+			s.OnSyscall(syscall_riscv64)
+			return
+		}
+	}
+	ip, _ := s.mu.RegRead(s.Config.Arch.GetRegIP())
+	log.WithFields(log.Fields{"at": hex(ip), "intno": intno}).Info("Interrupt, Invalid Instruction Event")
+	s.InvalidInstructionEvent(ip)
+	s.mu.Stop()
+}
@@ -37,6 +37,9 @@ func (s *WorkingSet) Map(addr, size uint64, em *Emulator) *errors.Error {
 		return wrap(err)
 	}
 	mem := em.Env.GetMem(base_addr, pagesize)
+	if em.Heap != nil && em.Heap.IsZeroed(base_addr) {
+		mem = make([]byte, pagesize)
+	}
 	if log_mem {
 		log.WithFields(log.Fields{"mem": mem[0:8]}).Debug("Memory written")
 	}
@@ -1,12 +1,51 @@
 package data_structures
 
+type EdgeKind uint
+
+const (
+  FALLTHROUGH   EdgeKind = 1 //to the next instruction, after a call or conditional jump or if the next block is a branch target
+  CONDITIONAL   EdgeKind = 2
+  UNCONDITIONAL EdgeKind = 3
+  CALL          EdgeKind = 4
+  RETURN        EdgeKind = 5
+)
+
+//Target is 0 for returns and for calls whose destination is not known statically
+type Edge struct {
+  Kind EdgeKind;
+  Target uint64;
+}
+
 type BB struct {
   Rng Range;
-  Transfers []uint64;
+  Transfers []uint64; //targets of the edges within the function, i.e. all but call and return edges
+  Edges []Edge;
+  Callees []uint64; //known call targets, they are no successors of the block
 }
 
 func NewBB(from uint64 ,to uint64, transfers []uint64) *BB{
   rng := NewRange(from, to)
-  return &BB{Rng: rng, Transfers: transfers}
+  return &BB{Rng: rng, Transfers: transfers, Edges: make([]Edge,0), Callees: make([]uint64,0)}
 }
 
+func (s *BB) AddEdge(kind EdgeKind, target uint64) {
+  s.Edges = append(s.Edges, Edge{Kind: kind, Target: target})
+  switch kind {
+    case CALL:
+      if target != 0 {
+        s.Callees = append(s.Callees, target)
+      }
+    case RETURN:
+    default:
+      s.Transfers = append(s.Transfers, target)
+  }
+}
+
+// NewBBWithEdges creates a block ending with the given edges
+func NewBBWithEdges(from uint64, to uint64, edges ...Edge) *BB {
+  bb := NewBB(from, to, make([]uint64,0))
+  for _, edge := range edges {
+    bb.AddEdge(edge.Kind, edge.Target)
+  }
+  return bb
+}
@@ -0,0 +1,168 @@
+package data_structures
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CallGraphNode is a function of the binary or an imported library function
+type CallGraphNode struct {
+	Addr   uint64 `json:"addr"`
+	Name   string `json:"name"`
+	Import bool   `json:"import,omitempty"`
+}
+
+// CallGraphEdge connects a caller to a callee, Sites are the addresses of the call instructions
+type CallGraphEdge struct {
+	From  uint64   `json:"from"`
+	To    uint64   `json:"to"`
+	Sites []uint64 `json:"sites"`
+}
+
+// CallGraph holds the direct calls between the functions of a binary, nodes are identified by their entry address
+// (imports by their ImportAddress)
+type CallGraph struct {
+	Nodes map[uint64]*CallGraphNode
+	Calls map[uint64]map[uint64][]uint64 //caller -> callee -> call sites
+}
+
+type addrList []uint64
+
+func (s addrList) Len() int           { return len(s) }
+func (s addrList) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s addrList) Less(i, j int) bool { return s[i] < s[j] }
+
+func NewCallGraph() *CallGraph {
+	return &CallGraph{Nodes: make(map[uint64]*CallGraphNode), Calls: make(map[uint64]map[uint64][]uint64)}
+}
+
+func (s *CallGraph) AddNode(addr uint64, name string, import_ bool) {
+	if _, ok := s.Nodes[addr]; !ok {
+		s.Nodes[addr] = &CallGraphNode{Addr: addr, Name: name, Import: import_}
+	}
+}
+
+// AddCall records the call at site, both functions have to be nodes already
+func (s *CallGraph) AddCall(from, to, site uint64) {
+	if _, ok := s.Calls[from]; !ok {
+		s.Calls[from] = make(map[uint64][]uint64)
+	}
+	s.Calls[from][to] = append(s.Calls[from][to], site)
+}
+
+// Callees returns the functions called by addr, ordered by address
+func (s *CallGraph) Callees(addr uint64) []uint64 {
+	res := make([]uint64, 0)
+	for callee := range s.Calls[addr] {
+		res = append(res, callee)
+	}
+	sort.Sort(addrList(res))
+	return res
+}
+
+// Callers returns the functions calling addr, ordered by address
+func (s *CallGraph) Callers(addr uint64) []uint64 {
+	res := make([]uint64, 0)
+	for caller, callees := range s.Calls {
+		if _, ok := callees[addr]; ok {
+			res = append(res, caller)
+		}
+	}
+	sort.Sort(addrList(res))
+	return res
+}
+
+// Neighborhood returns the functions reachable from addr by following at most depth calls in either direction
+func (s *CallGraph) Neighborhood(addr uint64, depth int) []uint64 {
+	seen := map[uint64]bool{addr: true}
+	current := []uint64{addr}
+	for i := 0; i < depth; i++ {
+		next := make([]uint64, 0)
+		for _, node := range current {
+			for _, neighbor := range append(s.Callees(node), s.Callers(node)...) {
+				if !seen[neighbor] {
+					seen[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		current = next
+	}
+	res := make([]uint64, 0, len(seen))
+	for node := range seen {
+		res = append(res, node)
+	}
+	sort.Sort(addrList(res))
+	return res
+}
+
+func (s *CallGraph) sortedNodes() []CallGraphNode {
+	addrs := make([]uint64, 0, len(s.Nodes))
+	for addr := range s.Nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Sort(addrList(addrs))
+	res := make([]CallGraphNode, 0, len(addrs))
+	for _, addr := range addrs {
+		res = append(res, *s.Nodes[addr])
+	}
+	return res
+}
+
+// Edges returns all edges, ordered by caller and callee
+func (s *CallGraph) Edges() []CallGraphEdge {
+	res := make([]CallGraphEdge, 0)
+	callers := make([]uint64, 0, len(s.Calls))
+	for caller := range s.Calls {
+		callers = append(callers, caller)
+	}
+	sort.Sort(addrList(callers))
+	for _, caller := range callers {
+		for _, callee := range s.Callees(caller) {
+			sites := append([]uint64{}, s.Calls[caller][callee]...)
+			sort.Sort(addrList(sites))
+			res = append(res, CallGraphEdge{From: caller, To: callee, Sites: sites})
+		}
+	}
+	return res
+}
+
+func (s *CallGraph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Nodes []CallGraphNode `json:"nodes"`
+		Edges []CallGraphEdge `json:"edges"`
+	}{s.sortedNodes(), s.Edges()})
+}
+
+// WriteDOT writes the graph in the format of graphviz, imports are drawn as boxes and edges are labeled with the
+// addresses of their call sites
+func (s *CallGraph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph callgraph {"); err != nil {
+		return err
+	}
+	for _, node := range s.sortedNodes() {
+		shape := "ellipse"
+		if node.Import {
+			shape = "box"
+		}
+		if _, err := fmt.Fprintf(w, "  \"0x%x\" [label=%q, shape=%s];\n", node.Addr, node.Name, shape); err != nil {
+			return err
+		}
+	}
+	for _, edge := range s.Edges() {
+		label := ""
+		for i, site := range edge.Sites {
+			if i > 0 {
+				label += ","
+			}
+			label += fmt.Sprintf("0x%x", site)
+		}
+		if _, err := fmt.Fprintf(w, "  \"0x%x\" -> \"0x%x\" [label=%q];\n", edge.From, edge.To, label); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
@@ -0,0 +1,35 @@
+package data_structures
+
+// CallSite is a call instruction, Target is 0 for indirect calls
+type CallSite struct {
+	Addr   uint64
+	Target uint64
+	Slot   uint64 //for indirect calls through a pointer at a fixed address (e.g. a GOT entry), 0 otherwise
+}
+
+// CFG is the control flow graph of a function, as found by following the transfers from its entry
+type CFG struct {
+	Entry       uint64
+	Blocks      map[uint64]BB
+	Succs       map[uint64][]uint64 //block start -> starts of the blocks control continues at
+	Preds       map[uint64][]uint64 //block start -> starts of the blocks control comes from
+	Unreachable []Range             //bytes of the function that are not part of any block
+	CallSites   []CallSite          //ordered by address
+	Returns     bool                //false if every path ends in a call of a noreturn function, hlt or ud2
+}
+
+func NewCFG(entry uint64) *CFG {
+	return &CFG{
+		Entry:       entry,
+		Blocks:      make(map[uint64]BB),
+		Succs:       make(map[uint64][]uint64),
+		Preds:       make(map[uint64][]uint64),
+		Unreachable: make([]Range, 0),
+		CallSites:   make([]CallSite, 0),
+	}
+}
+
+func (s *CFG) AddEdge(from, to uint64) {
+	s.Succs[from] = append(s.Succs[from], to)
+	s.Preds[to] = append(s.Preds[to], from)
+}
@@ -0,0 +1,47 @@
+package data_structures
+
+import (
+	"hash/fnv"
+)
+
+// Imported symbols are resolved to fake addresses in an otherwise unused part of the address space. The address only
+// depends on the name, so a call to an import looks the same in every binary.
+const (
+	ImportBase uint64 = 0x7e1f00000000
+	ImportSize uint64 = 0x100000000
+)
+
+// the imports of 32 bit code have to fit into 4 byte GOT slots, the region lies right above the 32 bit heap of the
+// emulator
+const (
+	ImportBase32 uint64 = 0xe1f00000
+	ImportSize32 uint64 = 0x1000000
+)
+
+func importRegion(pointerSize int) (uint64, uint64) {
+	if pointerSize == 4 {
+		return ImportBase32, ImportSize32
+	}
+	return ImportBase, ImportSize
+}
+
+// ImportAddress returns the fake address of the import name in code with pointers of pointerSize bytes
+func ImportAddress(name string, pointerSize int) uint64 {
+	base, size := importRegion(pointerSize)
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return base + (uint64(h.Sum32())<<4)%size
+}
+
+func IsImportAddress(addr uint64, pointerSize int) bool {
+	base, size := importRegion(pointerSize)
+	return base <= addr && addr < base+size
+}
+
+// NoReturnImports are library functions that never return to their caller
+var NoReturnImports = map[string]bool{
+	"exit": true, "_exit": true, "_Exit": true, "quick_exit": true, "abort": true,
+	"__stack_chk_fail": true, "__assert_fail": true, "__assert_perror_fail": true, "__fortify_fail": true, "__chk_fail": true,
+	"err": true, "errx": true, "verr": true, "verrx": true, "longjmp": true, "siglongjmp": true, "__longjmp_chk": true,
+	"pthread_exit": true, "__cxa_throw": true, "__cxa_rethrow": true, "_Unwind_Resume": true,
+}
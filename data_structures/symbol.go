@@ -14,6 +14,10 @@ const (
 type Symbol struct {
 	Name string
 	Type SymbolType
+	//the following is only known from debug information
+	File    string
+	Line    int
+	Inlined map[Range]*Symbol //code of other functions that was inlined into this one
 }
 
 func NewSymbol(name string, symtype SymbolType) *Symbol {
@@ -0,0 +1,54 @@
+package disassemble
+
+import (
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+type isa_aarch64 struct {
+	arch arch.Arch
+}
+
+func (s isa_aarch64) is_transfer(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins).IsTransfer()
+}
+
+func (s isa_aarch64) is_stop(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins) == arch.ClassTrap
+}
+
+func (isa_aarch64) call_slot(ins *gapstone.Instruction) uint64 { return 0 }
+
+// the label of a branch is its last operand (tbz x0, #bit, label)
+func aarch64_target(ins *gapstone.Instruction) (uint64, bool) {
+	ops := ins.Arm64.Operands
+	if len(ops) == 0 || ops[len(ops)-1].Type != gapstone.ARM64_OP_IMM {
+		return 0, false
+	}
+	return uint64(ops[len(ops)-1].Imm), true
+}
+
+func (s isa_aarch64) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := uint64(ins.Address) + uint64(ins.Size)
+	target, direct := aarch64_target(ins)
+	switch s.arch.Classify(ins) {
+	case arch.ClassReturn:
+		return append(res, ds.Edge{Kind: ds.RETURN})
+	case arch.ClassTrap, arch.ClassIndirect:
+		return res
+	case arch.ClassCall:
+		if !direct || ins.Id == gapstone.ARM64_INS_BLR {
+			target = 0
+		}
+		return append(res, ds.Edge{Kind: ds.CALL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassJump:
+		return append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+	}
+	//b.cond, cbz, cbnz, tbz and tbnz
+	if direct {
+		res = append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target})
+	}
+	return append(res, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+}
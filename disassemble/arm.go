@@ -0,0 +1,133 @@
+package disassemble
+
+import (
+	"encoding/binary"
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// isa_arm classifies 32 bit ARM and Thumb code. Every instruction may be executed conditionally, in ARM code by its
+// condition field and in Thumb code by an IT instruction in front of it. A conditional transfer falls through.
+type isa_arm struct {
+	arch  arch.Arch
+	thumb bool
+	it    map[uint64][]uint //instruction in an IT block -> conditions of it and of the rest of the block
+}
+
+func new_isa_arm(architecture arch.Arch) *isa_arm {
+	thumb := architecture.ToCapstoneModeDescription()&gapstone.CS_MODE_THUMB != 0
+	return &isa_arm{arch: architecture, thumb: thumb, it: make(map[uint64][]uint)}
+}
+
+// it_conditions decodes the conditions (as gapstone.ARM_CC_*) an IT instruction imposes on the up to four
+// instructions following it. The block is one instruction longer for every mask bit above the lowest set one, a mask
+// bit equal to the lowest bit of the first condition is a then, otherwise an else.
+func it_conditions(ins *gapstone.Instruction) []uint {
+	if len(ins.Bytes) < 2 {
+		return nil
+	}
+	hw := binary.LittleEndian.Uint16(ins.Bytes)
+	first, mask := uint(hw>>4)&0xf, uint(hw)&0xf
+	if hw&0xff00 != 0xbf00 || mask == 0 {
+		return nil
+	}
+	res := []uint{first + 1}
+	for bit := uint(3); mask&(1<<bit-1) != 0; bit-- {
+		if (mask>>bit)&1 == first&1 {
+			res = append(res, first+1)
+		} else {
+			res = append(res, first^1+1)
+		}
+	}
+	return res
+}
+
+// track records the IT blocks on the path to ins. Capstone decodes each instruction on its own and does not know
+// about the IT in front of it, so is_transfer has to see the instructions of a path in the order they are executed.
+func (s *isa_arm) track(ins *gapstone.Instruction) {
+	next := next_addr(ins)
+	if conds := s.it[uint64(ins.Address)]; len(conds) > 1 {
+		if _, ok := s.it[next]; !ok {
+			s.it[next] = conds[1:]
+		}
+	}
+	if ins.Id == gapstone.ARM_INS_IT {
+		s.it[next] = it_conditions(ins)
+	}
+}
+
+func (s *isa_arm) condition(ins *gapstone.Instruction) uint {
+	if conds := s.it[uint64(ins.Address)]; len(conds) > 0 {
+		return conds[0]
+	}
+	return ins.Arm.CC
+}
+
+func is_conditional(cc uint) bool {
+	return cc != gapstone.ARM_CC_INVALID && cc != gapstone.ARM_CC_AL
+}
+
+func (s *isa_arm) is_transfer(ins *gapstone.Instruction) bool {
+	s.track(ins)
+	return s.arch.Classify(ins).IsTransfer()
+}
+
+func (s *isa_arm) is_stop(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins) == arch.ClassTrap
+}
+
+func (s *isa_arm) call_slot(ins *gapstone.Instruction) uint64 { return 0 }
+
+// the label of a branch is its last operand (cbz r0, label)
+func arm_target(ins *gapstone.Instruction) (uint64, bool) {
+	ops := ins.Arm.Operands
+	if len(ops) == 0 || ops[len(ops)-1].Type != gapstone.ARM_OP_IMM {
+		return 0, false
+	}
+	return uint64(uint32(ops[len(ops)-1].Imm)), true
+}
+
+// call_target_address sets the lowest bit of the targets of calls into Thumb code, bl stays in the current
+// instruction set while blx to a label switches it
+func (s *isa_arm) call_target_address(ins *gapstone.Instruction, target uint64) uint64 {
+	thumb := s.thumb
+	if _, direct := arm_target(ins); direct && ins.Id == gapstone.ARM_INS_BLX {
+		thumb = !thumb
+	}
+	if thumb {
+		return target | 1
+	}
+	return target
+}
+
+func (s *isa_arm) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := next_addr(ins)
+	target, direct := arm_target(ins)
+	switch s.arch.Classify(ins) {
+	case arch.ClassTrap:
+		return res
+	case arch.ClassCall:
+		if !direct {
+			target = 0
+		}
+		return append(res, ds.Edge{Kind: ds.CALL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassConditional, arch.ClassJump:
+		//the condition of b may also come from an IT block
+		if ins.Id != gapstone.ARM_INS_B {
+			return append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+		}
+		if !is_conditional(s.condition(ins)) {
+			return append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+		}
+		res = append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target})
+	case arch.ClassReturn:
+		res = append(res, ds.Edge{Kind: ds.RETURN})
+	}
+	//bx, tbb, tbh and the other writes to the pc are indirect jumps without known targets
+	if is_conditional(s.condition(ins)) {
+		res = append(res, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	}
+	return res
+}
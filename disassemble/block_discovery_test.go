@@ -3,6 +3,7 @@ package disassemble
 import (
 	"testing"
   "fmt"
+	"github.com/ranmrdrakono/indika/arch"
 	ds "github.com/ranmrdrakono/indika/data_structures"
 	"reflect"
 )
@@ -20,13 +21,14 @@ code = "\x55\x48\x89\xe5\x89\x7d\xec\x89\x75\xe8\x8b" +
 
 func TestRun(t *testing.T) {
     expected_result := make(map[uint64]ds.BB)
-    expected_result[0x1000] = *ds.NewBB(0x1000,0x1022, []uint64{0x1036,0x1022})
-    expected_result[0x1022] = *ds.NewBB(0x1022,0x1036, []uint64{0x1056,0x1036})
-    expected_result[0x1036] = *ds.NewBB(0x1036,0x104f, []uint64{0x1056,0x104f})
-    expected_result[0x104f] = *ds.NewBB(0x104f,0x1056, []uint64{0x105b,0x1056})
-    expected_result[0x1056] = *ds.NewBB(0x1056,0x105d, []uint64{})
+    expected_result[0x1000] = *ds.NewBBWithEdges(0x1000,0x1022, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x1036}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x1022})
+    expected_result[0x1022] = *ds.NewBBWithEdges(0x1022,0x1036, ds.Edge{Kind: ds.UNCONDITIONAL, Target: 0x1056})
+    expected_result[0x1036] = *ds.NewBBWithEdges(0x1036,0x104f, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x1056}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x104f})
+    expected_result[0x104f] = *ds.NewBBWithEdges(0x104f,0x1056, ds.Edge{Kind: ds.UNCONDITIONAL, Target: 0x105b})
+    expected_result[0x1056] = *ds.NewBBWithEdges(0x1056,0x105b, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x105b}) //split, since the jmp at 0x1054 lands in it
+    expected_result[0x105b] = *ds.NewBBWithEdges(0x105b,0x105d, ds.Edge{Kind: ds.RETURN})
 
-    blocks := GetBBs(0x1000, []byte(code), ds.NewRange(0x1000,0x1000+uint64(len(code))))
+    blocks := GetBBs(0x1000, []byte(code), ds.NewRange(0x1000,0x1000+uint64(len(code))), nil)
     if !reflect.DeepEqual(blocks, expected_result) {
       fmt.Printf("Is: %#v\n", blocks)
       fmt.Printf("Sh: %#v\n", expected_result)
@@ -34,4 +36,276 @@ func TestRun(t *testing.T) {
     }
 }
 
+func TestCFG(t *testing.T) {
+    cfg := GetCFG(0x1000, []byte(code), ds.NewRange(0x1000,0x1000+uint64(len(code))), nil)
+    if !reflect.DeepEqual(cfg.Succs[0x1000], []uint64{0x1036,0x1022}) || !reflect.DeepEqual(cfg.Preds[0x105b], []uint64{0x104f,0x1056}) {
+      fmt.Printf("Succs: %#v\nPreds: %#v\n", cfg.Succs, cfg.Preds)
+      t.Fail()
+    }
+    if len(cfg.Unreachable) != 0 {
+      fmt.Printf("Unreachable: %#v\n", cfg.Unreachable)
+      t.Fail()
+    }
+
+    //xor eax, eax; ret; followed by data
+    cfg = GetCFG(0x2000, []byte("\x31\xc0\xc3\xff\xff"), ds.NewRange(0x2000,0x2005), nil)
+    expected_blocks := map[uint64]ds.BB{0x2000: *ds.NewBBWithEdges(0x2000,0x2003, ds.Edge{Kind: ds.RETURN})}
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !reflect.DeepEqual(cfg.Unreachable, []ds.Range{ds.NewRange(0x2003,0x2005)}) {
+      fmt.Printf("Blocks: %#v\nUnreachable: %#v\n", cfg.Blocks, cfg.Unreachable)
+      t.Fail()
+    }
+}
+
+func TestCallSites(t *testing.T) {
+    //call 0x5000; call rax; ret
+    code := "\xe8\xfb\xff\xff\xff\xff\xd0\xc3"
+    cfg := GetCFG(0x5000, []byte(code), ds.NewRange(0x5000,0x5008), nil)
+    expected_blocks := map[uint64]ds.BB{
+      0x5000: *ds.NewBBWithEdges(0x5000,0x5005, ds.Edge{Kind: ds.CALL, Target: 0x5000}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x5005}),
+      0x5005: *ds.NewBBWithEdges(0x5005,0x5007, ds.Edge{Kind: ds.CALL}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x5007}),
+      0x5007: *ds.NewBBWithEdges(0x5007,0x5008, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    //the recursive call is no edge within the function
+    if !reflect.DeepEqual(cfg.Succs[0x5000], []uint64{0x5005}) || len(cfg.Preds[0x5000]) != 0 {
+      fmt.Printf("Succs: %#v\nPreds: %#v\n", cfg.Succs, cfg.Preds)
+      t.Fail()
+    }
+    expected_calls := []ds.CallSite{ds.CallSite{Addr: 0x5000, Target: 0x5000}, ds.CallSite{Addr: 0x5005, Target: 0}}
+    if calls := GetCallSites(0x5000, []byte(code), ds.NewRange(0x5000,0x5008), nil); !reflect.DeepEqual(calls, expected_calls) {
+      fmt.Printf("Is: %#v\nSh: %#v\n", calls, expected_calls)
+      t.Fail()
+    }
+}
+
+func TestNoReturn(t *testing.T) {
+    //call 0x7000; xor eax, eax; ret
+    code := "\xe8\xfb\x0f\x00\x00\x31\xc0\xc3"
+    cfg := GetCFG(0x6000, []byte(code), ds.NewRange(0x6000,0x6008), &Context{NoReturn: map[uint64]bool{0x7000: true}})
+    expected_blocks := map[uint64]ds.BB{0x6000: *ds.NewBBWithEdges(0x6000,0x6005, ds.Edge{Kind: ds.CALL, Target: 0x7000})}
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !reflect.DeepEqual(cfg.Unreachable, []ds.Range{ds.NewRange(0x6005,0x6008)}) || cfg.Returns {
+      fmt.Printf("Blocks: %#v\nUnreachable: %#v\nReturns: %v\n", cfg.Blocks, cfg.Unreachable, cfg.Returns)
+      t.Fail()
+    }
+    if cfg = GetCFG(0x6000, []byte(code), ds.NewRange(0x6000,0x6008), nil); len(cfg.Blocks) != 2 || !cfg.Returns {
+      fmt.Printf("Blocks: %#v\nReturns: %v\n", cfg.Blocks, cfg.Returns)
+      t.Fail()
+    }
+
+    //ud2; xor eax, eax; ret
+    code = "\x0f\x0b\x31\xc0\xc3"
+    cfg = GetCFG(0x6000, []byte(code), ds.NewRange(0x6000,0x6005), nil)
+    expected_blocks = map[uint64]ds.BB{0x6000: *ds.NewBBWithEdges(0x6000,0x6002)}
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || cfg.Returns {
+      fmt.Printf("Blocks: %#v\nReturns: %v\n", cfg.Blocks, cfg.Returns)
+      t.Fail()
+    }
+}
+
+//cbz x0, 0x100c; bl 0x1100; b 0x1010; add x0, x0, #1; ret
+var aarch64_code = "\x60\x00\x00\xb4\x3f\x00\x00\x94\x02\x00\x00\x14\x00\x04\x00\x91\xc0\x03\x5f\xd6"
+
+func TestAArch64(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchAArch64{}}
+    cfg := GetCFG(0x1000, []byte(aarch64_code), ds.NewRange(0x1000,0x1014), ctx)
+    expected_blocks := map[uint64]ds.BB{
+      0x1000: *ds.NewBBWithEdges(0x1000,0x1004, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x100c}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x1004}),
+      0x1004: *ds.NewBBWithEdges(0x1004,0x1008, ds.Edge{Kind: ds.CALL, Target: 0x1100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x1008}),
+      0x1008: *ds.NewBBWithEdges(0x1008,0x100c, ds.Edge{Kind: ds.UNCONDITIONAL, Target: 0x1010}),
+      0x100c: *ds.NewBBWithEdges(0x100c,0x1010, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x1010}),
+      0x1010: *ds.NewBBWithEdges(0x1010,0x1014, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    if targets := GetCallTargets(0x1000, []byte(aarch64_code), ds.NewRange(0x1000,0x1014), ctx); !reflect.DeepEqual(targets, []uint64{0x1100}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
+//cmp r0, #0; it eq; bxeq lr; cbz r1, 0x200e; bl 0x2100; pop {r4, pc}; ite ne; movne r0, #1; popeq {r4, pc}; b 0x2006
+var thumb_code = "\x00\x28\x08\xbf\x70\x47\x11\xb1\x00\xf0\x7a\xf8\x10\xbd\x14\xbf\x01\x20\x10\xbd\xf7\xe7"
+
+func TestThumb(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchARM{Thumb: true}}
+    cfg := GetCFG(0x2000, []byte(thumb_code), ds.NewRange(0x2000,0x2016), ctx)
+    expected_blocks := map[uint64]ds.BB{
+      0x2000: *ds.NewBBWithEdges(0x2000,0x2006, ds.Edge{Kind: ds.RETURN}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x2006}),
+      0x2006: *ds.NewBBWithEdges(0x2006,0x2008, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x200e}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x2008}),
+      0x2008: *ds.NewBBWithEdges(0x2008,0x200c, ds.Edge{Kind: ds.CALL, Target: 0x2100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x200c}),
+      0x200c: *ds.NewBBWithEdges(0x200c,0x200e, ds.Edge{Kind: ds.RETURN}),
+      0x200e: *ds.NewBBWithEdges(0x200e,0x2014, ds.Edge{Kind: ds.RETURN}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x2014}),
+      0x2014: *ds.NewBBWithEdges(0x2014,0x2016, ds.Edge{Kind: ds.UNCONDITIONAL, Target: 0x2006}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns || len(cfg.Unreachable) != 0 {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    //bl stays in Thumb code
+    if targets := GetCallTargets(0x2000, []byte(thumb_code), ds.NewRange(0x2000,0x2016), ctx); !reflect.DeepEqual(targets, []uint64{0x2101}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
+//cmp r0, #0; bxeq lr; blx 0x3100; ldr pc, [sp], #4
+var arm_code = "\x00\x00\x50\xe3\x1e\xff\x2f\x01\x3c\x00\x00\xfa\x04\xf0\x9d\xe4"
+
+func TestARM(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchARM{}}
+    cfg := GetCFG(0x3000, []byte(arm_code), ds.NewRange(0x3000,0x3010), ctx)
+    expected_blocks := map[uint64]ds.BB{
+      0x3000: *ds.NewBBWithEdges(0x3000,0x3008, ds.Edge{Kind: ds.RETURN}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x3008}),
+      0x3008: *ds.NewBBWithEdges(0x3008,0x300c, ds.Edge{Kind: ds.CALL, Target: 0x3100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x300c}),
+      0x300c: *ds.NewBBWithEdges(0x300c,0x3010, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    //blx to a label switches to Thumb code
+    if targets := GetCallTargets(0x3000, []byte(arm_code), ds.NewRange(0x3000,0x3010), ctx); !reflect.DeepEqual(targets, []uint64{0x3101}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
+//switch statements, the first with a table of absolute addresses (cmp edi, 2; ja; mov edi, edi; jmp [rdi*8+0x4000])
+//the second with offsets relative to the table (lea rdx, [rip+0x1000]; cmp edi, 2; jbe; ...; movsxd rax, [rdx+rdi*4]; add rax, rdx; jmp rax)
+var switch_code = "\x83\xff\x02\x77\x15\x89\xff\xff\x24\xfd\x00\x40\x00\x00\xb8\x01\x00\x00\x00\xc3\xb8\x02\x00\x00\x00\xc3\x31\xc0\xc3\x48\x8d\x15\x00\x10\x00\x00\x83\xff\x02\x76\x03\x31\xc0\xc3\x89\xff\x48\x63\x04\xba\x48\x01\xd0\xff\xe0\xb8\x01\x00\x00\x00\xc3\xb8\x02\x00\x00\x00\xc3"
+var switch_tables = "\x0e\x30\x00\x00\x00\x00\x00\x00\x14\x30\x00\x00\x00\x00\x00\x00\x1a\x30\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x13\xf0\xff\xff\x19\xf0\xff\xff\x05\xf0\xff\xff"
+
+func TestJumpTable(t *testing.T) {
+    tables := []*ds.MappedRegion{ds.NewMappedRegion([]byte(switch_tables), ds.R, ds.NewRange(0x4000,0x4000+uint64(len(switch_tables))))}
+    cfg := GetCFG(0x3000, []byte(switch_code), ds.NewRange(0x3000,0x301d), &Context{Data: tables})
+    if !reflect.DeepEqual(cfg.Blocks[0x3005].Transfers, []uint64{0x300e,0x3014,0x301a}) || len(cfg.Unreachable) != 0 {
+      fmt.Printf("Blocks: %#v\nUnreachable: %#v\n", cfg.Blocks, cfg.Unreachable)
+      t.Fail()
+    }
+    cfg = GetCFG(0x3000, []byte(switch_code), ds.NewRange(0x301d,0x3043), &Context{Data: tables})
+    if !reflect.DeepEqual(cfg.Blocks[0x302c].Transfers, []uint64{0x3037,0x303d,0x3029}) || !reflect.DeepEqual(cfg.Preds[0x303d], []uint64{0x302c}) {
+      fmt.Printf("Blocks: %#v\nPreds: %#v\n", cfg.Blocks, cfg.Preds)
+      t.Fail()
+    }
+
+    //tables in writable memory may change at runtime
+    writable := []*ds.MappedRegion{ds.NewMappedRegion([]byte(switch_tables), ds.R|ds.W, ds.NewRange(0x4000,0x4000+uint64(len(switch_tables))))}
+    cfg = GetCFG(0x3000, []byte(switch_code), ds.NewRange(0x3000,0x301d), &Context{Data: writable})
+    if len(cfg.Blocks[0x3005].Transfers) != 0 {
+      fmt.Printf("Blocks: %#v\n", cfg.Blocks)
+      t.Fail()
+    }
+}
+
+//beqz $a0, 0x4010; move $v0, $zero; jal 0x4100; addiu $a0, $a0, 1; jr $ra; nop (big endian)
+var mips_code = "\x10\x80\x00\x03\x00\x00\x10\x21\x0c\x00\x10\x40\x24\x84\x00\x01\x03\xe0\x00\x08\x00\x00\x00\x00"
+
+func TestMIPS(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchMIPS{}}
+    cfg := GetCFG(0x4000, []byte(mips_code), ds.NewRange(0x4000,0x4018), ctx)
+    //the delay slots belong to the blocks of the transfers, control continues behind them
+    expected_blocks := map[uint64]ds.BB{
+      0x4000: *ds.NewBBWithEdges(0x4000,0x4008, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x4010}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x4008}),
+      0x4008: *ds.NewBBWithEdges(0x4008,0x4010, ds.Edge{Kind: ds.CALL, Target: 0x4100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x4010}),
+      0x4010: *ds.NewBBWithEdges(0x4010,0x4018, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns || len(cfg.Unreachable) != 0 {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    if targets := GetCallTargets(0x4000, []byte(mips_code), ds.NewRange(0x4000,0x4018), ctx); !reflect.DeepEqual(targets, []uint64{0x4100}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
+//cmpwi r3, 0; beqlr; bcl 20, 31, 0x500c; bne 0x5018; bl 0x5100; trap; blr
+var ppc_code = "\x2c\x03\x00\x00\x4d\x82\x00\x20\x42\x9f\x00\x05\x40\x82\x00\x0c\x48\x00\x00\xf1\x7f\xe0\x00\x08\x4e\x80\x00\x20"
+
+func TestPPC(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchPPC{}}
+    cfg := GetCFG(0x5000, []byte(ppc_code), ds.NewRange(0x5000,0x501c), ctx)
+    expected_blocks := map[uint64]ds.BB{
+      0x5000: *ds.NewBBWithEdges(0x5000,0x5008, ds.Edge{Kind: ds.RETURN}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x5008}),
+      0x5008: *ds.NewBBWithEdges(0x5008,0x5010, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x5018}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x5010}),
+      0x5010: *ds.NewBBWithEdges(0x5010,0x5014, ds.Edge{Kind: ds.CALL, Target: 0x5100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x5014}),
+      0x5014: *ds.NewBBWithEdges(0x5014,0x5018),
+      0x5018: *ds.NewBBWithEdges(0x5018,0x501c, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    //bcl to the next instruction only reads the pc
+    if targets := GetCallTargets(0x5000, []byte(ppc_code), ds.NewRange(0x5000,0x501c), ctx); !reflect.DeepEqual(targets, []uint64{0x5100}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
 //O0 strings str_reverse  [[4195646,4195664],[4195669,4195678],[4195680,4195681],[4195607,4195626],[4195631,4195644]].map{|x| x.map{|y| y.to_s 16 }}
+
+//c.beqz a0, 0x600c; auipc ra, 0; jalr ra, 0xfe(ra); c.jr ra; auipc t1, 0; jalr zero, 0x200(t1)
+var riscv_code = "\x11\xc5\x97\x00\x00\x00\xe7\x80\xe0\x0f\x82\x80\x17\x03\x00\x00\x67\x00\x03\x20"
+
+func TestRISCV64(t *testing.T) {
+    ctx := &Context{Arch: &arch.ArchRISCV64{}}
+    cfg := GetCFG(0x6000, []byte(riscv_code), ds.NewRange(0x6000,0x6014), ctx)
+    //compressed and full size instructions are mixed, the targets of jalr come from the auipc in front of them
+    expected_blocks := map[uint64]ds.BB{
+      0x6000: *ds.NewBBWithEdges(0x6000,0x6002, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x600c}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x6002}),
+      0x6002: *ds.NewBBWithEdges(0x6002,0x600a, ds.Edge{Kind: ds.CALL, Target: 0x6100}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x600a}),
+      0x600a: *ds.NewBBWithEdges(0x600a,0x600c, ds.Edge{Kind: ds.RETURN}),
+      0x600c: *ds.NewBBWithEdges(0x600c,0x6014, ds.Edge{Kind: ds.UNCONDITIONAL, Target: 0x620c}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns || len(cfg.Unreachable) != 0 {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+    if targets := GetCallTargets(0x6000, []byte(riscv_code), ds.NewRange(0x6000,0x6014), ctx); !reflect.DeepEqual(targets, []uint64{0x6100}) {
+      fmt.Printf("call targets %#v\n", targets)
+      t.Fail()
+    }
+}
+
+func TestReturns(t *testing.T) {
+    //cmp edi, 2; ja 0x7008; ret 8; retf
+    code := "\x83\xff\x02\x77\x03\xc2\x08\x00\xcb"
+    cfg := GetCFG(0x7000, []byte(code), ds.NewRange(0x7000,0x7009), nil)
+    expected_blocks := map[uint64]ds.BB{
+      0x7000: *ds.NewBBWithEdges(0x7000,0x7005, ds.Edge{Kind: ds.CONDITIONAL, Target: 0x7008}, ds.Edge{Kind: ds.FALLTHROUGH, Target: 0x7005}),
+      0x7005: *ds.NewBBWithEdges(0x7005,0x7008, ds.Edge{Kind: ds.RETURN}),
+      0x7008: *ds.NewBBWithEdges(0x7008,0x7009, ds.Edge{Kind: ds.RETURN}),
+    }
+    if !reflect.DeepEqual(cfg.Blocks, expected_blocks) || !cfg.Returns {
+      fmt.Printf("Is: %#v\n", cfg.Blocks)
+      fmt.Printf("Sh: %#v\n", expected_blocks)
+      t.Fail()
+    }
+
+    //the emulator sees the same returns through a Decoder
+    decoder, err := NewDecoder(&arch.ArchX86_64{})
+    if err != nil {
+      t.Fatal(err)
+    }
+    defer decoder.Close()
+    for _, addr := range []uint64{0x7005, 0x7008} {
+      ins := decoder.Decode(addr, []byte(code[addr-0x7000:]))
+      if ins == nil || (&arch.ArchX86_64{}).Classify(ins) != arch.ClassReturn {
+        fmt.Printf("no return at %x: %#v\n", addr, ins)
+        t.Fail()
+      }
+    }
+}
@@ -4,156 +4,127 @@ import (
 //	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
 	ds "github.com/ranmrdrakono/indika/data_structures"
   "fmt"
 )
 
-/* jump instructions */
-var jmp_flags = make(map[uint]bool)
-var jmp_no_return_flags = make(map[uint]bool)
-
-func init() {
-	/* set of jmp/call instructions */
-	jmp_flags[gapstone.X86_INS_JL] = true
-	jmp_flags[gapstone.X86_INS_JLE] = true
-	jmp_flags[gapstone.X86_INS_JA] = true
-	jmp_flags[gapstone.X86_INS_JAE] = true
-	jmp_flags[gapstone.X86_INS_JB] = true
-	jmp_flags[gapstone.X86_INS_JBE] = true
-	jmp_flags[gapstone.X86_INS_JCXZ] = true
-	jmp_flags[gapstone.X86_INS_JECXZ] = true
-	jmp_flags[gapstone.X86_INS_JO] = true
-	jmp_flags[gapstone.X86_INS_JNO] = true
-	jmp_flags[gapstone.X86_INS_JS] = true
-	jmp_flags[gapstone.X86_INS_JNS] = true
-	jmp_flags[gapstone.X86_INS_JP] = true
-	jmp_flags[gapstone.X86_INS_JNP] = true
-	jmp_flags[gapstone.X86_INS_JE] = true
-	jmp_flags[gapstone.X86_INS_JNE] = true
-	jmp_flags[gapstone.X86_INS_JG] = true
-	jmp_flags[gapstone.X86_INS_JGE] = true
-	jmp_flags[gapstone.X86_INS_CALL] = true
-	jmp_flags[gapstone.X86_INS_LCALL] = true
-	jmp_flags[gapstone.X86_INS_JMP] = true
-	jmp_flags[gapstone.X86_INS_LJMP] = true
-	jmp_flags[gapstone.X86_INS_RET] = true
-	jmp_flags[gapstone.X86_INS_RETF] = true
-}
-
-func is_transfer(ins gapstone.Instruction) bool {
-  _,ok := jmp_flags[ins.Id]
-  return ok
-}
-
-func has_target(ins gapstone.Instruction) bool {
-  switch ins.Id {
-    case gapstone.X86_INS_RET, gapstone.X86_INS_RETF: 
-      return false
-    default: 
-      return true
-  }
-}
-
-func has_skip_transfer(ins gapstone.Instruction) bool {
-  switch ins.Id {
-    case gapstone.X86_INS_RET, gapstone.X86_INS_RETF: 
-      return false
-    case gapstone.X86_INS_JMP, gapstone.X86_INS_LJMP: 
-      return false
-    default: 
-      return true
-  }
-}
-
-
 func makebb(ins gapstone.Instruction) *ds.BB{
   from := uint64(ins.Address)
   to := uint64(ins.Address)+uint64(ins.Size)
   return ds.NewBB(from, to, make([]uint64,0))
 }
 
-func get_transfer_targets( ins gapstone.Instruction) []uint64 {
-      res := make([]uint64,0)
-      if has_target(ins){
-        for _, op := range ins.X86.Operands {
-          if op.Type == gapstone.X86_OP_IMM {
-            res = append(res, uint64(op.Imm))
-          }
-        }
-      }
-      if has_skip_transfer(ins){
-			/* instruction succeeding a jump => new basic block*/
-        res = append(res,uint64(ins.Address)+uint64(ins.Size))
-      }
-      return res
-}
-
-func search_basicblocks(ins []gapstone.Instruction) map[uint64]ds.BB{
-  res := make(map[uint64]ds.BB)
-  var curr_bb *ds.BB = nil
-
-  for _, curr_instr := range ins {
-    if curr_bb == nil {
-      curr_bb = makebb(curr_instr)
-    }
-    //this is a jump instruction => add current bb
-    if is_transfer(curr_instr) {
-      curr_bb.Transfers = get_transfer_targets(curr_instr)
-      curr_bb.Rng.To = uint64(curr_instr.Address+curr_instr.Size)
-      res[curr_bb.Rng.From] = *curr_bb
-      curr_bb = nil
-    }
-  }
-
-  //finish last basic block
-  if curr_bb != nil {
-    last_instr := ins[len(ins)-1]
-    curr_bb.Rng.To = uint64(last_instr.Address + last_instr.Size)
-    res[curr_bb.Rng.From] = *curr_bb
-  }
-  return res
+// direct_targets are the immediate destinations of a transfer
+func direct_targets(ins gapstone.Instruction) []uint64 {
+	res := make([]uint64, 0)
+	for _, op := range ins.X86.Operands {
+		if op.Type == gapstone.X86_OP_IMM {
+			res = append(res, uint64(op.Imm))
+		}
+	}
+	return res
 }
 
-func GetBBs(codeoffset uint64, code []byte, function_bounds ds.Range) map[uint64]ds.BB {
-	if function_bounds.To-function_bounds.From < 1 {
-		return make(map[uint64]ds.BB)
+// get_edges gives the edges of the transfer ins of class, the targets of indirect jumps are not known here
+func get_edges(ins gapstone.Instruction, class arch.InstructionClass) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := uint64(ins.Address) + uint64(ins.Size)
+	switch class {
+	case arch.ClassReturn:
+		return append(res, ds.Edge{Kind: ds.RETURN})
+	case arch.ClassTrap, arch.ClassIndirect:
+		return res
+	case arch.ClassCall:
+		targets := direct_targets(ins)
+		if len(targets) == 0 {
+			res = append(res, ds.Edge{Kind: ds.CALL})
+		}
+		for _, target := range targets {
+			res = append(res, ds.Edge{Kind: ds.CALL, Target: target})
+		}
+		return append(res, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassJump:
+		for _, target := range direct_targets(ins) {
+			res = append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+		}
+		return res
+	}
+	for _, target := range direct_targets(ins) {
+		res = append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target})
 	}
+	return append(res, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+}
 
+func disassemble_range(codeoffset uint64, code []byte, function_bounds ds.Range, architecture arch.Arch) ([]gapstone.Instruction, error) {
 	EP := function_bounds.From
-	engine, err := gapstone.New(gapstone.CS_ARCH_X86, gapstone.CS_MODE_64)
-
-	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Fatal("Failed to create Gapstone Disassembler")
-	}
 	if EP-codeoffset > uint64(len(code)) || EP < codeoffset || function_bounds.To > codeoffset+uint64(len(code)) {
 		log.WithFields(log.Fields{"function range": function_bounds, "code offset": codeoffset, "len of code": len(code)}).Fatal("invalid offset in code")
 	}
+	engine := open_engine(architecture)
+	defer engine.Close()
 
 	offset_in_code := EP - codeoffset
 	code = code[offset_in_code : offset_in_code+function_bounds.Length()]
-	/* detailed options. enables parsing jump arguments*/
-	engine.SetOption(gapstone.CS_OPT_DETAIL, gapstone.CS_OPT_ON)
-
-	defer engine.Close()
 	/* disassemble code */
-	instrs, err := engine.Disasm(code, EP, 0)
+	return engine.Disasm(code, EP, 0)
+}
 
-	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Fatal("Failed to Disassemble")
-	}
+// GetCallSites returns the calls in the blocks reachable from the start of function_bounds, ordered by address
+func GetCallSites(codeoffset uint64, code []byte, function_bounds ds.Range, ctx *Context) []ds.CallSite {
+	return GetCFG(codeoffset, code, function_bounds, ctx).CallSites
+}
 
-	return search_basicblocks(instrs)
+// GetBBs returns the basic blocks reachable from the start of function_bounds, see GetCFG
+func GetBBs(codeoffset uint64, code []byte, function_bounds ds.Range, ctx *Context) map[uint64]ds.BB {
+	return GetCFG(codeoffset, code, function_bounds, ctx).Blocks
 }
 
-func InspectMemory(addr uint64, code[]byte) string {
-  engine, err := gapstone.New(gapstone.CS_ARCH_X86, gapstone.CS_MODE_64)
+// GetCallTargets returns the destinations of all direct calls in function_bounds, calls into Thumb code have the lowest
+// bit of their target set
+func GetCallTargets(codeoffset uint64, code []byte, function_bounds ds.Range, ctx *Context) []uint64 {
+	res := make([]uint64, 0)
+	if function_bounds.To-function_bounds.From < 1 {
+		return res
+	}
+	architecture := get_arch(ctx)
+	isa := get_instruction_set(architecture)
+	instrs, err := disassemble_range(codeoffset, code, function_bounds, architecture)
+	if err != nil { //discovery also sweeps over data, this is no reason to give up
+		log.WithFields(log.Fields{"error": err, "function range": function_bounds}).Info("Failed to Disassemble")
+		return res
+	}
+	for i := range instrs {
+		if !isa.is_transfer(&instrs[i]) {
+			continue
+		}
+		for _, edge := range isa.get_edges(&instrs[i]) {
+			if edge.Kind != ds.CALL || edge.Target == 0 {
+				continue
+			}
+			if iw, ok := isa.(interworking); ok {
+				res = append(res, iw.call_target_address(&instrs[i], edge.Target))
+			} else {
+				res = append(res, edge.Target)
+			}
+		}
+	}
+	return res
+}
+
+func InspectMemory(addr uint64, code[]byte, architecture arch.Arch) string {
+  engine, err := new_disassembler(architecture)
   if err != nil {
     return "DA Fail: "+err.Error()
   }
-	instrs, err := engine.Disasm(code, addr, 0)
+  defer engine.Close()
+	instrs, err := engine.Disasm(code, addr, 1)
   if err != nil {
     return "DA Fail: "+err.Error()
   }
+  if len(instrs) == 0 {
+    return "DA Fail: invalid instruction"
+  }
   ins := instrs[0]
   return fmt.Sprintf("%s %s",ins.Mnemonic, ins.OpStr)
 }
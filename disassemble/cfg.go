@@ -0,0 +1,262 @@
+package disassemble
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"sort"
+)
+
+//longest instruction of all supported architectures (x86)
+const max_instruction_size = 15
+
+type addrArray []uint64
+
+func (s addrArray) Len() int           { return len(s) }
+func (s addrArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s addrArray) Less(i, j int) bool { return s[i] < s[j] }
+
+// Context is what the disassembler knows about the binary beyond the code of a function, a nil Context is empty
+type Context struct {
+	Arch     arch.Arch          //x86-64 if nil
+	Data     []*ds.MappedRegion //jump tables are read from the read only ones
+	NoReturn map[uint64]bool    //call targets and pointer slots (see ds.CallSite) of functions that never return
+}
+
+// decoder disassembles single instructions of a function on demand
+type decoder struct {
+	engine     disassembler
+	isa        instruction_set
+	codeoffset uint64
+	code       []byte
+	bounds     ds.Range
+	data       []*ds.MappedRegion
+	noreturn   map[uint64]bool
+	cache      map[uint64]*gapstone.Instruction
+	prev       map[uint64]uint64   //instruction -> the instruction the descent reached it from
+	tables     map[uint64][]uint64 //indirect jmp -> cases of its jump table
+}
+
+func (d *decoder) at(addr uint64) *gapstone.Instruction {
+	if ins, ok := d.cache[addr]; ok {
+		return ins
+	}
+	var res *gapstone.Instruction
+	if d.bounds.From <= addr && addr < d.bounds.To {
+		end := addr + max_instruction_size
+		if end > d.bounds.To {
+			end = d.bounds.To
+		}
+		instrs, err := d.engine.Disasm(d.code[addr-d.codeoffset:end-d.codeoffset], addr, 1)
+		if err == nil && len(instrs) == 1 {
+			res = &instrs[0]
+		}
+	}
+	d.cache[addr] = res
+	return res
+}
+
+func next_addr(ins *gapstone.Instruction) uint64 {
+	return uint64(ins.Address) + uint64(ins.Size)
+}
+
+// delay_slot returns the instruction executed before the transfer ins takes effect, nil if there is none
+func (d *decoder) delay_slot(ins *gapstone.Instruction) *gapstone.Instruction {
+	if isa, ok := d.isa.(delayed); ok && isa.has_delay_slot(ins) {
+		return d.at(next_addr(ins))
+	}
+	return nil
+}
+
+// edges are the transfers of ins, including the cases of its jump table. Calls of noreturn functions do not fall
+// through.
+func (d *decoder) edges(ins *gapstone.Instruction) []ds.Edge {
+	res := d.isa.get_edges(ins)
+	if len(res) > 0 && res[0].Kind == ds.CALL && (d.noreturn[res[0].Target] || d.noreturn[d.isa.call_slot(ins)]) {
+		res = res[:len(res)-1]
+	}
+	for _, target := range d.jump_table_targets(ins) {
+		res = append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+	}
+	return res
+}
+
+// successors are the addresses within the function that control continues at after ins, callees are not part of it
+func (d *decoder) successors(ins *gapstone.Instruction) []uint64 {
+	res := make([]uint64, 0)
+	if !d.isa.is_transfer(ins) {
+		return append(res, next_addr(ins))
+	}
+	for _, edge := range d.edges(ins) {
+		if edge.Kind == ds.CALL || edge.Kind == ds.RETURN {
+			continue
+		}
+		if d.bounds.From <= edge.Target && edge.Target < d.bounds.To {
+			res = append(res, edge.Target)
+		}
+	}
+	return res
+}
+
+// leaves is true if control may return to the caller after the block ending with ins. Tail calls of functions that
+// return and indirect jumps that could not be resolved count as returns, as do blocks that end without any transfer.
+func (d *decoder) leaves(ins *gapstone.Instruction, leaders map[uint64]bool) bool {
+	if !d.isa.is_transfer(ins) {
+		return !leaders[next_addr(ins)]
+	}
+	edges := d.edges(ins)
+	if len(edges) == 0 {
+		return !d.isa.is_stop(ins)
+	}
+	for _, edge := range edges {
+		switch {
+		case edge.Kind == ds.RETURN:
+			return true
+		case edge.Kind == ds.CALL:
+		case (edge.Target < d.bounds.From || edge.Target >= d.bounds.To) && !d.noreturn[edge.Target]:
+			return true
+		}
+	}
+	return false
+}
+
+// call_slot returns the address of the pointer an indirect call reads its target from, if it is fixed
+func call_slot(ins *gapstone.Instruction) uint64 {
+	if len(ins.X86.Operands) != 1 || ins.X86.Operands[0].Type != gapstone.X86_OP_MEM {
+		return 0
+	}
+	mem := ins.X86.Operands[0].Mem
+	switch {
+	case mem.Index != gapstone.X86_REG_INVALID:
+		return 0
+	case mem.Base == gapstone.X86_REG_RIP:
+		return next_addr(ins) + uint64(mem.Disp)
+	case mem.Base == gapstone.X86_REG_INVALID:
+		return uint64(mem.Disp)
+	}
+	return 0
+}
+
+// find_leaders follows all transfers from the entry, every branch target and every instruction following a transfer
+// starts a block
+func (d *decoder) find_leaders(entry uint64) map[uint64]bool {
+	leaders := map[uint64]bool{entry: true}
+	seen := make(map[uint64]bool)
+	worklist := []uint64{entry}
+	for len(worklist) > 0 {
+		addr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		ins := d.at(addr)
+		if ins == nil {
+			continue
+		}
+		for _, succ := range d.successors(ins) {
+			if d.isa.is_transfer(ins) {
+				leaders[succ] = true
+			}
+			if _, ok := d.prev[succ]; !ok && succ != entry {
+				d.prev[succ] = addr
+			}
+			worklist = append(worklist, succ)
+		}
+	}
+	return leaders
+}
+
+// unreachable returns the parts of the function not covered by any block
+func unreachable(bounds ds.Range, blocks map[uint64]ds.BB) []ds.Range {
+	res := make([]ds.Range, 0)
+	starts := make([]uint64, 0, len(blocks))
+	for addr := range blocks {
+		starts = append(starts, addr)
+	}
+	sort.Sort(addrArray(starts))
+	covered := bounds.From
+	for _, addr := range starts {
+		if addr > covered {
+			res = append(res, ds.NewRange(covered, addr))
+		}
+		if blocks[addr].Rng.To > covered {
+			covered = blocks[addr].Rng.To
+		}
+	}
+	if covered < bounds.To {
+		res = append(res, ds.NewRange(covered, bounds.To))
+	}
+	return res
+}
+
+// GetCFG recovers the control flow graph of the function in function_bounds by recursive descent from its start.
+// Calls are not followed, they are recorded in the CallSites and as call edges of their blocks. Blocks end at
+// transfers (or behind their delay slot) and are split wherever another transfer lands, bytes that are never reached
+// (e.g. data, padding or code after calls of noreturn functions) are reported in Unreachable instead of being
+// disassembled. The cases of switch statements are found in the jump tables of the ctx Data.
+func GetCFG(codeoffset uint64, code []byte, function_bounds ds.Range, ctx *Context) *ds.CFG {
+	cfg := ds.NewCFG(function_bounds.From)
+	if function_bounds.To-function_bounds.From < 1 {
+		return cfg
+	}
+	if function_bounds.From < codeoffset || function_bounds.To > codeoffset+uint64(len(code)) {
+		log.WithFields(log.Fields{"function range": function_bounds, "code offset": codeoffset, "len of code": len(code)}).Fatal("invalid offset in code")
+	}
+	architecture := get_arch(ctx)
+	engine := open_engine(architecture)
+	defer engine.Close()
+
+	if ctx == nil {
+		ctx = &Context{}
+	}
+	d := &decoder{engine: engine, isa: get_instruction_set(architecture), codeoffset: codeoffset, code: code, bounds: function_bounds, data: ctx.Data, noreturn: ctx.NoReturn,
+		cache: make(map[uint64]*gapstone.Instruction), prev: make(map[uint64]uint64), tables: make(map[uint64][]uint64)}
+	leaders := d.find_leaders(function_bounds.From)
+	sorted := make([]uint64, 0, len(leaders))
+	for leader := range leaders {
+		sorted = append(sorted, leader)
+	}
+	sort.Sort(addrArray(sorted))
+
+	for _, leader := range sorted {
+		ins := d.at(leader)
+		if ins == nil {
+			continue
+		}
+		bb := makebb(*ins)
+		for !d.isa.is_transfer(ins) {
+			next := d.at(next_addr(ins))
+			if next == nil || leaders[next_addr(ins)] {
+				break
+			}
+			ins = next
+		}
+		bb.Rng.To = next_addr(ins)
+		if slot := d.delay_slot(ins); slot != nil {
+			bb.Rng.To = next_addr(slot)
+		}
+		if d.isa.is_transfer(ins) {
+			for _, edge := range d.edges(ins) {
+				bb.AddEdge(edge.Kind, edge.Target)
+				if edge.Kind == ds.CALL {
+					cfg.CallSites = append(cfg.CallSites, ds.CallSite{Addr: uint64(ins.Address), Target: edge.Target, Slot: d.isa.call_slot(ins)})
+				}
+			}
+		} else if leaders[next_addr(ins)] { //falls through into the next block
+			bb.AddEdge(ds.FALLTHROUGH, next_addr(ins))
+		}
+		cfg.Blocks[leader] = *bb
+		if d.leaves(ins, leaders) {
+			cfg.Returns = true
+		}
+		for _, succ := range d.successors(ins) {
+			if leaders[succ] && d.at(succ) != nil {
+				cfg.AddEdge(leader, succ)
+			}
+		}
+	}
+	cfg.Unreachable = unreachable(function_bounds, cfg.Blocks)
+	return cfg
+}
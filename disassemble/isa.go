@@ -0,0 +1,134 @@
+package disassemble
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// instruction_set classifies the instructions of one architecture for the block discovery
+type instruction_set interface {
+	is_transfer(ins *gapstone.Instruction) bool
+	get_edges(ins *gapstone.Instruction) []ds.Edge
+	is_stop(ins *gapstone.Instruction) bool     //execution does not continue after ins (e.g. hlt)
+	call_slot(ins *gapstone.Instruction) uint64 //see ds.CallSite
+}
+
+// interworking is implemented by instruction sets that can switch the encoding on calls (ARM and Thumb). The address of
+// a call target then tells its encoding, as for the function pointers of the architecture.
+type interworking interface {
+	call_target_address(ins *gapstone.Instruction, target uint64) uint64
+}
+
+// delayed is implemented by instruction sets with delay slots (MIPS). The instruction following such a transfer is
+// executed before control leaves, it belongs to the block of the transfer and the edges of the transfer skip it.
+type delayed interface {
+	has_delay_slot(ins *gapstone.Instruction) bool
+}
+
+// isa_x86_64 classifies x86 code of both modes, the edges of a transfer follow from its class and its immediate
+// operands
+type isa_x86_64 struct {
+	arch arch.Arch
+}
+
+func (s isa_x86_64) is_transfer(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins).IsTransfer()
+}
+
+func (s isa_x86_64) is_stop(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins) == arch.ClassTrap
+}
+
+func (s isa_x86_64) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	return get_edges(*ins, s.arch.Classify(ins))
+}
+
+func (isa_x86_64) call_slot(ins *gapstone.Instruction) uint64 { return call_slot(ins) }
+
+func get_arch(ctx *Context) arch.Arch {
+	if ctx == nil || ctx.Arch == nil {
+		return &arch.ArchX86_64{}
+	}
+	return ctx.Arch
+}
+
+func get_instruction_set(architecture arch.Arch) instruction_set {
+	switch architecture.ToCapstoneArchDescription() {
+	case gapstone.CS_ARCH_ARM64:
+		return isa_aarch64{architecture}
+	case gapstone.CS_ARCH_ARM:
+		return new_isa_arm(architecture)
+	case gapstone.CS_ARCH_MIPS:
+		return isa_mips{architecture}
+	case gapstone.CS_ARCH_PPC:
+		return isa_ppc{architecture}
+	case arch.CS_ARCH_RISCV:
+		return new_isa_riscv(architecture)
+	}
+	return isa_x86_64{architecture}
+}
+
+// disassembler decodes code into instructions, it is a *gapstone.Engine unless Capstone can't decode the architecture
+type disassembler interface {
+	Disasm(input []byte, address, count uint64) ([]gapstone.Instruction, error)
+	Close() error
+}
+
+func new_disassembler(architecture arch.Arch) (disassembler, error) {
+	if architecture.ToCapstoneArchDescription() == arch.CS_ARCH_RISCV {
+		return riscv_splitter{}, nil
+	}
+	engine, err := gapstone.New(architecture.ToCapstoneArchDescription(), architecture.ToCapstoneModeDescription())
+	if err != nil {
+		return nil, err
+	}
+	return &engine, nil
+}
+
+// open_engine creates a disassembler with details (needed for the operands of transfers) for architecture
+func open_engine(architecture arch.Arch) disassembler {
+	engine, err := new_disassembler(architecture)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Fatal("Failed to create Gapstone Disassembler")
+	}
+	enable_details(engine)
+	return engine
+}
+
+func enable_details(engine disassembler) {
+	/* detailed options. enables parsing jump arguments*/
+	if capstone, ok := engine.(*gapstone.Engine); ok {
+		capstone.SetOption(gapstone.CS_OPT_DETAIL, gapstone.CS_OPT_ON)
+	}
+}
+
+// Decoder decodes single instructions with the details arch.Arch.Classify needs, for code that is seen one
+// instruction at a time (as by the emulator)
+type Decoder struct {
+	engine disassembler
+}
+
+// NewDecoder creates a Decoder for architecture, it has to be closed
+func NewDecoder(architecture arch.Arch) (*Decoder, error) {
+	engine, err := new_disassembler(architecture)
+	if err != nil {
+		return nil, err
+	}
+	enable_details(engine)
+	return &Decoder{engine: engine}, nil
+}
+
+// Decode decodes the instruction at the start of code, nil if it is invalid
+func (s *Decoder) Decode(addr uint64, code []byte) *gapstone.Instruction {
+	instrs, err := s.engine.Disasm(code, addr, 1)
+	if err != nil || len(instrs) == 0 {
+		return nil
+	}
+	return &instrs[0]
+}
+
+func (s *Decoder) Close() error {
+	return s.engine.Close()
+}
@@ -0,0 +1,215 @@
+package disassemble
+
+import (
+	"encoding/binary"
+	log "github.com/Sirupsen/logrus"
+	"github.com/bnagy/gapstone"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+//number of instructions before an indirect jmp that are searched for the table and its bound
+const max_table_lookback = 12
+
+//tables claiming more entries are most likely not switch statements
+const max_table_entries = 1024
+
+// the 32 bit registers an index is usually compared with, before being used as 64 bit index
+var reg_32_to_64 = map[uint]uint{
+	gapstone.X86_REG_EAX: gapstone.X86_REG_RAX, gapstone.X86_REG_EBX: gapstone.X86_REG_RBX,
+	gapstone.X86_REG_ECX: gapstone.X86_REG_RCX, gapstone.X86_REG_EDX: gapstone.X86_REG_RDX,
+	gapstone.X86_REG_ESI: gapstone.X86_REG_RSI, gapstone.X86_REG_EDI: gapstone.X86_REG_RDI,
+	gapstone.X86_REG_EBP: gapstone.X86_REG_RBP, gapstone.X86_REG_ESP: gapstone.X86_REG_RSP,
+	gapstone.X86_REG_R8D: gapstone.X86_REG_R8, gapstone.X86_REG_R9D: gapstone.X86_REG_R9,
+	gapstone.X86_REG_R10D: gapstone.X86_REG_R10, gapstone.X86_REG_R11D: gapstone.X86_REG_R11,
+	gapstone.X86_REG_R12D: gapstone.X86_REG_R12, gapstone.X86_REG_R13D: gapstone.X86_REG_R13,
+	gapstone.X86_REG_R14D: gapstone.X86_REG_R14, gapstone.X86_REG_R15D: gapstone.X86_REG_R15,
+}
+
+func full_reg(reg uint) uint {
+	if full, ok := reg_32_to_64[reg]; ok {
+		return full
+	}
+	return reg
+}
+
+type jump_table struct {
+	addr       uint64
+	entry_size uint64 //8: absolute addresses, 4: signed offsets relative to addr
+	index      uint
+	used       int //number of instructions before the jmp that belong to the table lookup
+}
+
+// preceding returns up to n instructions executed before ins, closest first, following the path the descent took
+func (d *decoder) preceding(ins *gapstone.Instruction, n int) []*gapstone.Instruction {
+	res := make([]*gapstone.Instruction, 0, n)
+	addr := uint64(ins.Address)
+	for len(res) < n {
+		prev, ok := d.prev[addr]
+		if !ok {
+			break
+		}
+		prev_ins := d.at(prev)
+		if prev_ins == nil {
+			break
+		}
+		res = append(res, prev_ins)
+		addr = prev
+	}
+	return res
+}
+
+func is_reg(op gapstone.X86Operand, reg uint) bool {
+	return op.Type == gapstone.X86_OP_REG && full_reg(op.Reg) == reg
+}
+
+// match_relative_table finds lea base, [rip+table]; movsxd target, dword [base+index*4]; add target, base
+func match_relative_table(target uint, before []*gapstone.Instruction) (jump_table, bool) {
+	var base uint
+	found_add, found_load := false, false
+	res := jump_table{entry_size: 4}
+	for i, ins := range before {
+		ops := ins.X86.Operands
+		if len(ops) != 2 {
+			continue
+		}
+		switch {
+		case !found_add && ins.Id == gapstone.X86_INS_ADD && is_reg(ops[0], target) && ops[1].Type == gapstone.X86_OP_REG:
+			base, found_add = full_reg(ops[1].Reg), true
+		case found_add && !found_load && ins.Id == gapstone.X86_INS_MOVSXD && is_reg(ops[0], target) && ops[1].Type == gapstone.X86_OP_MEM &&
+			full_reg(ops[1].Mem.Base) == base && ops[1].Mem.Scale == 4:
+			res.index, found_load = full_reg(ops[1].Mem.Index), true
+			res.used = i + 1
+		}
+	}
+	if !found_load {
+		return res, false
+	}
+	//the lea is often scheduled before the compare of the index, so it may be anywhere before the load
+	for _, ins := range before[res.used:] {
+		ops := ins.X86.Operands
+		if ins.Id == gapstone.X86_INS_LEA && len(ops) == 2 && is_reg(ops[0], base) && ops[1].Type == gapstone.X86_OP_MEM &&
+			ops[1].Mem.Base == gapstone.X86_REG_RIP && ops[1].Mem.Index == gapstone.X86_REG_INVALID {
+			res.addr = next_addr(ins) + uint64(ops[1].Mem.Disp)
+			return res, true
+		}
+	}
+	return res, false
+}
+
+// table_bound finds the number of entries from the compare of the index and the unsigned branch to the default case
+// that guard the lookup. The path taken from the branch has to be the one that stays within the table.
+func table_bound(index uint, before []*gapstone.Instruction, next []uint64) (uint64, bool) {
+	var jcc *gapstone.Instruction
+	taken := false
+	for i, ins := range before {
+		ops := ins.X86.Operands
+		switch {
+		case jcc == nil && (ins.Id == gapstone.X86_INS_JA || ins.Id == gapstone.X86_INS_JAE || ins.Id == gapstone.X86_INS_JB || ins.Id == gapstone.X86_INS_JBE):
+			jcc, taken = ins, next[i] != next_addr(ins)
+		case jcc == nil && ins.Id == gapstone.X86_INS_MOV && len(ops) == 2 && is_reg(ops[0], index) && ops[1].Type == gapstone.X86_OP_REG:
+			index = full_reg(ops[1].Reg) //zero extension of the index (mov eax, edi)
+		case jcc != nil && ins.Id == gapstone.X86_INS_CMP && len(ops) == 2 && is_reg(ops[0], index) && ops[1].Type == gapstone.X86_OP_IMM:
+			bound := uint64(ops[1].Imm)
+			switch {
+			case jcc.Id == gapstone.X86_INS_JA && !taken, jcc.Id == gapstone.X86_INS_JBE && taken:
+				return bound + 1, true
+			case jcc.Id == gapstone.X86_INS_JAE && !taken, jcc.Id == gapstone.X86_INS_JB && taken:
+				return bound, true
+			}
+			return 0, false
+		case jcc != nil && len(ops) > 0 && is_reg(ops[0], index):
+			return 0, false //the index is modified between compare and branch
+		}
+	}
+	return 0, false
+}
+
+func (d *decoder) read_table(addr, size uint64) []byte {
+	for _, region := range d.data {
+		if region.Flags&ds.W != 0 || addr < region.Range.From {
+			continue
+		}
+		offset := addr - region.Range.From
+		if offset+size <= uint64(len(region.Data)) && offset+size >= offset {
+			return region.Data[offset : offset+size]
+		}
+	}
+	return nil
+}
+
+// find_table matches the instructions that load the target of an indirect jmp, either from a table of absolute
+// addresses (jmp [index*8+table]) or from a table of offsets relative to itself
+func find_table(ins *gapstone.Instruction, before []*gapstone.Instruction) (jump_table, bool) {
+	op := ins.X86.Operands[0]
+	switch op.Type {
+	case gapstone.X86_OP_MEM:
+		if op.Mem.Base != gapstone.X86_REG_INVALID || op.Mem.Index == gapstone.X86_REG_INVALID || op.Mem.Scale != 8 {
+			return jump_table{}, false
+		}
+		return jump_table{addr: uint64(op.Mem.Disp), entry_size: 8, index: full_reg(op.Mem.Index)}, true
+	case gapstone.X86_OP_REG:
+		return match_relative_table(full_reg(op.Reg), before)
+	}
+	return jump_table{}, false
+}
+
+// jump_table_targets recovers the cases of a switch statement compiled to an indirect jmp. The table has to lie in a
+// read only region and all of its entries have to point into the function, otherwise the jmp keeps no targets.
+func (d *decoder) jump_table_targets(ins *gapstone.Instruction) []uint64 {
+	if _, x86 := d.isa.(isa_x86_64); !x86 {
+		return nil
+	}
+	if ins.Id != gapstone.X86_INS_JMP || len(ins.X86.Operands) != 1 || ins.X86.Operands[0].Type == gapstone.X86_OP_IMM {
+		return nil
+	}
+	if targets, ok := d.tables[uint64(ins.Address)]; ok {
+		return targets
+	}
+	d.tables[uint64(ins.Address)] = nil
+
+	before := d.preceding(ins, max_table_lookback)
+	next := make([]uint64, len(before)) //the address executed after each of before
+	for i := range before {
+		if i == 0 {
+			next[i] = uint64(ins.Address)
+		} else {
+			next[i] = uint64(before[i-1].Address)
+		}
+	}
+	table, ok := find_table(ins, before)
+	if !ok {
+		return nil
+	}
+	count, ok := table_bound(table.index, before[table.used:], next[table.used:])
+	if !ok || count == 0 || count > max_table_entries {
+		log.WithFields(log.Fields{"at": ins.Address, "table": table.addr}).Info("Jump table without bound")
+		return nil
+	}
+	entries := d.read_table(table.addr, count*table.entry_size)
+	if entries == nil {
+		log.WithFields(log.Fields{"at": ins.Address, "table": table.addr, "entries": count}).Info("Jump table not in read only data")
+		return nil
+	}
+
+	res := make([]uint64, 0, count)
+	seen := make(map[uint64]bool)
+	for i := uint64(0); i < count; i++ {
+		entry := entries[i*table.entry_size : (i+1)*table.entry_size]
+		var target uint64
+		if table.entry_size == 8 {
+			target = binary.LittleEndian.Uint64(entry)
+		} else {
+			target = table.addr + uint64(int64(int32(binary.LittleEndian.Uint32(entry))))
+		}
+		if target < d.bounds.From || target >= d.bounds.To {
+			log.WithFields(log.Fields{"at": ins.Address, "table": table.addr, "target": target}).Info("Jump table entry outside of function")
+			return nil
+		}
+		if !seen[target] {
+			seen[target] = true
+			res = append(res, target)
+		}
+	}
+	d.tables[uint64(ins.Address)] = res
+	return res
+}
@@ -0,0 +1,57 @@
+package disassemble
+
+import (
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// isa_mips classifies MIPS32 code. Jumps and branches have a delay slot, so control continues behind the instruction
+// following them.
+type isa_mips struct {
+	arch arch.Arch
+}
+
+func (s isa_mips) is_transfer(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins).IsTransfer()
+}
+
+func (s isa_mips) is_stop(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins) == arch.ClassTrap
+}
+
+func (isa_mips) call_slot(ins *gapstone.Instruction) uint64 { return 0 }
+
+// every transfer but break has a delay slot, a branch in a delay slot is undefined
+func (s isa_mips) has_delay_slot(ins *gapstone.Instruction) bool {
+	return s.is_transfer(ins) && !s.is_stop(ins)
+}
+
+// the label of a branch is its last operand (beq $a0, $a1, label)
+func mips_target(ins *gapstone.Instruction) (uint64, bool) {
+	ops := ins.Mips.Operands
+	if len(ops) == 0 || ops[len(ops)-1].Type != gapstone.MIPS_OP_IMM {
+		return 0, false
+	}
+	return uint64(uint32(ops[len(ops)-1].Imm)), true
+}
+
+func (s isa_mips) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := next_addr(ins) + 4 //behind the delay slot
+	target, direct := mips_target(ins)
+	switch s.arch.Classify(ins) {
+	case arch.ClassReturn:
+		return append(res, ds.Edge{Kind: ds.RETURN})
+	case arch.ClassTrap, arch.ClassIndirect:
+		return res
+	case arch.ClassCall:
+		if !direct {
+			target = 0
+		}
+		return append(res, ds.Edge{Kind: ds.CALL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassJump:
+		return append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+	}
+	return append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+}
@@ -0,0 +1,49 @@
+package disassemble
+
+import (
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// isa_ppc decodes the targets of 32 bit PowerPC branches from their encoding, Capstone names conditional branches by
+// their simplified mnemonics (beq, bdnz, bnelr, ...). A conditional bclr or bcctr falls through.
+type isa_ppc struct {
+	arch arch.Arch
+}
+
+func (s isa_ppc) is_transfer(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins).IsTransfer()
+}
+
+func (s isa_ppc) is_stop(ins *gapstone.Instruction) bool {
+	return s.arch.Classify(ins) == arch.ClassTrap
+}
+
+func (isa_ppc) call_slot(ins *gapstone.Instruction) uint64 { return 0 }
+
+func (s isa_ppc) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := next_addr(ins)
+	target, direct := arch.PPCBranchTarget(ins)
+	switch s.arch.Classify(ins) {
+	case arch.ClassTrap:
+		return res
+	case arch.ClassCall: //bl, bcl and the calls through the link (blrl) or count register (bctrl)
+		if !direct {
+			target = 0
+		}
+		return append(res, ds.Edge{Kind: ds.CALL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassJump:
+		return append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+	case arch.ClassConditional:
+		return append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassReturn:
+		res = append(res, ds.Edge{Kind: ds.RETURN})
+	}
+	//bctr is an indirect jump without known targets
+	if !arch.PPCBranchAlways(ins) {
+		res = append(res, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+package disassemble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/bnagy/gapstone"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// riscv_splitter cuts RISC-V code into instructions by their length, which the lowest two bits tell: 11 for 4 byte
+// instructions, anything else for the 2 byte ones of the C extension. The instructions are left undecoded,
+// arch.ArchRISCV64 classifies them by their encoding.
+type riscv_splitter struct{}
+
+func (riscv_splitter) Close() error { return nil }
+
+func (riscv_splitter) Disasm(input []byte, address, count uint64) ([]gapstone.Instruction, error) {
+	res := make([]gapstone.Instruction, 0)
+	for offset := 0; offset+2 <= len(input) && (count == 0 || uint64(len(res)) < count); {
+		size := 2
+		if input[offset]&3 == 3 {
+			size = 4
+			if input[offset]&0x1f == 0x1f { //48 bit and longer encodings are not used by RV64GC
+				break
+			}
+		}
+		if offset+size > len(input) {
+			break
+		}
+		bytes := input[offset : offset+size]
+		word := uint32(binary.LittleEndian.Uint16(bytes))
+		if size == 4 {
+			word = binary.LittleEndian.Uint32(bytes)
+		}
+		res = append(res, gapstone.Instruction{InstructionHeader: gapstone.InstructionHeader{
+			Address: uint(address) + uint(offset), Size: uint(size), Bytes: bytes,
+			Mnemonic: ".insn", OpStr: fmt.Sprintf("0x%x", word),
+		}})
+		offset += size
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no RISC-V instruction at 0x%x", address)
+	}
+	return res, nil
+}
+
+// isa_riscv decodes the targets of RV64GC transfers. Far calls and tail calls load the upper bits of their target with
+// an auipc in front of the jalr, which turns the indirect jalr of a tail call into a jump.
+type isa_riscv struct {
+	arch  arch.Arch
+	auipc map[uint64]riscv_auipc //instruction following an auipc -> the register and the address it set
+}
+
+type riscv_auipc struct {
+	reg   uint32
+	value uint64
+}
+
+func new_isa_riscv(architecture arch.Arch) *isa_riscv {
+	return &isa_riscv{arch: architecture, auipc: make(map[uint64]riscv_auipc)}
+}
+
+// track records the auipc on the path to ins, so that the jalr following it has a known target (call and tail)
+func (s *isa_riscv) track(ins *gapstone.Instruction) {
+	if reg, value, ok := arch.RISCVAuipc(ins); ok {
+		s.auipc[next_addr(ins)] = riscv_auipc{reg: reg, value: value}
+	}
+}
+
+// target is the destination of the transfer ins, where it is known. The target of a jalr is only known if an auipc in
+// front of it set its base register.
+func (s *isa_riscv) target(ins *gapstone.Instruction) (uint64, bool) {
+	if base, offset, ok := arch.RISCVJalr(ins); ok {
+		if prev, ok := s.auipc[uint64(ins.Address)]; ok && prev.reg == base {
+			return prev.value + offset, true
+		}
+		return 0, false
+	}
+	return arch.RISCVTarget(ins)
+}
+
+// classify refines the class the Arch gives ins by the auipc in front of it
+func (s *isa_riscv) classify(ins *gapstone.Instruction) arch.InstructionClass {
+	class := s.arch.Classify(ins)
+	if _, direct := s.target(ins); class == arch.ClassIndirect && direct {
+		return arch.ClassJump //tail
+	}
+	return class
+}
+
+func (s *isa_riscv) is_transfer(ins *gapstone.Instruction) bool {
+	s.track(ins)
+	return s.classify(ins).IsTransfer()
+}
+
+func (s *isa_riscv) is_stop(ins *gapstone.Instruction) bool {
+	return s.classify(ins) == arch.ClassTrap
+}
+
+func (s *isa_riscv) call_slot(ins *gapstone.Instruction) uint64 { return 0 }
+
+func (s *isa_riscv) get_edges(ins *gapstone.Instruction) []ds.Edge {
+	res := make([]ds.Edge, 0)
+	next := next_addr(ins)
+	target, _ := s.target(ins)
+	switch s.classify(ins) {
+	case arch.ClassJump:
+		return append(res, ds.Edge{Kind: ds.UNCONDITIONAL, Target: target})
+	case arch.ClassConditional:
+		return append(res, ds.Edge{Kind: ds.CONDITIONAL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassCall:
+		return append(res, ds.Edge{Kind: ds.CALL, Target: target}, ds.Edge{Kind: ds.FALLTHROUGH, Target: next})
+	case arch.ClassReturn:
+		return append(res, ds.Edge{Kind: ds.RETURN})
+	}
+	return res //traps and jump tables
+}
@@ -1,79 +1,36 @@
 package main
 
 import (
-	"debug/elf"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/go-errors/errors"
 	be "github.com/ranmrdrakono/indika/blanket_emulator"
 	ds "github.com/ranmrdrakono/indika/data_structures"
-	"github.com/ranmrdrakono/indika/disassemble"
-	"github.com/ranmrdrakono/indika/arch"
-	loader "github.com/ranmrdrakono/indika/loader/elf"
-	"io"
+	"github.com/ranmrdrakono/indika/loader"
 	"os"
 	//	"reflect"
 	"encoding/hex"
+	"encoding/json"
 )
 
-func find_mapping_for(maps map[ds.Range]*ds.MappedRegion, needle ds.Range) *ds.MappedRegion {
-	for rng, mapping := range maps {
-		if rng.IntersectsRange(needle) {
-			return mapping
-		}
-	}
-	return nil
-}
-func filter_empty_bbs(bbs map[uint64]ds.BB) map[uint64]ds.BB {
-	res := make(map[uint64]ds.BB)
-	for addr, bb := range bbs {
-		if !bb.Rng.IsEmpty() {
-			res[addr] = bb
-		}
-	}
-	return res
-}
-
-func extract_bbs(maps map[ds.Range]*ds.MappedRegion, rng ds.Range) map[uint64]ds.BB {
-	maped := find_mapping_for(maps, rng)
-	if maped == nil {
-		return nil
-	}
-	blocks := disassemble.GetBBs(maped.Range.From, maped.Data, rng)
-	return filter_empty_bbs(blocks)
-}
-
-func MakeBlanketEmulator(mem map[ds.Range]*ds.MappedRegion) *be.Emulator {
+func MakeBlanketEmulator(bin *loader.Binary, function uint64) *be.Emulator {
 	config := be.Config{
 		MaxTraceInstructionCount: 100,
 		MaxTraceTime:             0,
 		MaxTracePages:            50,
-		Arch:                     &arch.ArchX86_64{},
+		Arch:                     bin.ArchAt(function),
+		Library:                  be.NewLibc(),
+		Imports:                  bin.ImportTargets(),
+		Function:                 function,
 	}
-  env := be.NewRandEnv(0)
-	em := be.NewEmulator(mem, config, env)
-	return em
-}
-
-func ioReader(file string) io.ReaderAt {
-	r, err := os.Open(file)
-	if err != nil {
-		log.WithFields(log.Fields{"error": err}).Fatal("Error creating File Reader")
+	if gp, ok := bin.GlobalPointer(); ok {
+		config.GlobalPointer = gp
 	}
-	return r
-}
-
-func wrap(err error) *errors.Error {
-	if err != nil {
-		return errors.Wrap(err, 1)
-	}
-	return nil
-}
-
-func check(err *errors.Error) {
-	if err != nil && err.Err != nil {
-		log.WithFields(log.Fields{"error": err, "stack": err.ErrorStack()}).Fatal("Error creating Elf Parser")
+	if frame := bin.FrameInfo(function); frame != nil {
+		config.Frame = frame
 	}
+  env := be.NewRandEnv(0)
+	em := be.NewEmulator(bin.Segments, config, env)
+	return em
 }
 
 func pad_func_name(str string) string {
@@ -102,22 +59,44 @@ func are_we_interessted_in_this(symb *ds.Symbol) bool{
     return true
 }
 
+func write_call_graph(bin *loader.Binary) {
+	graph := bin.CallGraph()
+	if len(os.Args) >= 4 && os.Args[3] == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(graph); err != nil {
+			log.WithFields(log.Fields{"error": err}).Fatal("Error writing Call Graph")
+		}
+		return
+	}
+	if err := graph.WriteDOT(os.Stdout); err != nil {
+		log.WithFields(log.Fields{"error": err}).Fatal("Error writing Call Graph")
+	}
+}
+
 func main() {
 	file := os.Args[1]
 
 	log.SetLevel(log.ErrorLevel)
 
-	fmt.Printf("%v\n", os.Args)
+	call_graph := len(os.Args) >= 3 && os.Args[2] == "callgraph" //hasher <file> callgraph [dot|json]
+	if !call_graph {
+		fmt.Printf("%v\n", os.Args)
+	}
 
 	if len(os.Args) >= 3 && os.Args[2] == "d" {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	f := ioReader(file)
-	_elf, err := elf.NewFile(f)
-	check(wrap(err))
-	maps := loader.GetSegments(_elf)
-	symbols := loader.GetSymbols(_elf)
+	bin, err := loader.Open(file)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "file": file}).Fatal("Error loading Binary")
+	}
+	if call_graph {
+		write_call_graph(bin)
+		return
+	}
+
+	maps := bin.Segments
+	symbols := bin.Symbols
 
 	fmt.Println("done loading")
 	fmt.Printf("maps %v\n", maps)
@@ -126,12 +105,12 @@ func main() {
     if !are_we_interessted_in_this(symb) {
       continue
     }
-    bbs := extract_bbs(maps, rng)
+    bbs := bin.ExtractBBs(rng)
     if len(bbs) == 0 {
       continue
     }
     fmt.Printf("%v : ", pad_func_name(symb.Name))
-    emulator := MakeBlanketEmulator(maps)
+    emulator := MakeBlanketEmulator(bin, rng.From)
     err := emulator.FullBlanket(bbs)
     if err != nil {
       log.WithFields(log.Fields{"error": err}).Error("Error running Blanket")
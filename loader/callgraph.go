@@ -0,0 +1,57 @@
+package loader
+
+import (
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// callee resolves the destination of a call site to a node of the call graph, calls into PLT stubs and through GOT
+// slots end at the import they are bound to
+func (s *Binary) callee(site ds.CallSite) (uint64, string, bool) {
+	if name, ok := s.ImportName(site.Target); site.Target != 0 && ok {
+		return s.importAddress(name), name, true
+	}
+	if name, ok := s.Imports[site.Slot]; site.Slot != 0 && ok {
+		return s.importAddress(name), name, true
+	}
+	return site.Target, "", false
+}
+
+// CallGraph connects the functions in Symbols by the direct calls found in their control flow graphs. Indirect calls
+// are only resolved if they go through the GOT slot of an import.
+func (s *Binary) CallGraph() *ds.CallGraph {
+	return s.callGraph(s.ExtractCFG)
+}
+
+// callGraph builds the call graph from the control flow graphs extract returns for the functions in Symbols
+func (s *Binary) callGraph(extract func(ds.Range) *ds.CFG) *ds.CallGraph {
+	graph := ds.NewCallGraph()
+	functions := make(map[ds.Range]*ds.Symbol)
+	for rng, symbol := range s.Symbols {
+		if symbol.Type == ds.FUNC && !rng.IsEmpty() {
+			if _, stub := s.PLT[rng.From]; !stub {
+				functions[rng] = symbol
+				graph.AddNode(rng.From, symbol.Name, false)
+			}
+		}
+	}
+
+	for rng := range functions {
+		cfg := extract(rng)
+		if cfg == nil {
+			continue
+		}
+		for _, site := range cfg.CallSites {
+			target, name, imported := s.callee(site)
+			if imported {
+				graph.AddNode(target, name, true)
+			}
+			if _, ok := graph.Nodes[target]; !ok {
+				log.WithFields(log.Fields{"site": site.Addr, "target": target}).Debug("Call to unknown function")
+				continue
+			}
+			graph.AddCall(rng.From, target, site.Addr)
+		}
+	}
+	return graph
+}
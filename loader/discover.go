@@ -0,0 +1,105 @@
+package loader
+
+import (
+	"fmt"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"github.com/ranmrdrakono/indika/disassemble"
+	"sort"
+)
+
+type uint64Array []uint64
+
+func (s uint64Array) Len() int           { return len(s) }
+func (s uint64Array) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint64Array) Less(i, j int) bool { return s[i] < s[j] }
+
+func hasFunctions(symbols map[ds.Range]*ds.Symbol) bool {
+	for rng, symbol := range symbols {
+		if symbol.Type == ds.FUNC && !rng.IsEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// codeRegionAt returns the executable region containing addr, only the part backed by data counts
+func (s *Binary) codeRegionAt(addr uint64) *ds.MappedRegion {
+	region := s.RegionAt(addr)
+	if region == nil || region.Flags&ds.X == 0 || addr >= region.Range.From+uint64(len(region.Data)) {
+		return nil
+	}
+	return region
+}
+
+// functionEnd is the start of the next known function or the end of the code in region
+func functionEnd(addr uint64, region *ds.MappedRegion, starts map[uint64]bool) uint64 {
+	end := region.Range.From + uint64(len(region.Data))
+	for start := range starts {
+		if addr < start && start < end {
+			end = start
+		}
+	}
+	return end
+}
+
+// DiscoverFunctions finds the functions of a binary without symbol table. The ranges of known functions (e.g. exports
+// or unwind information) are kept, while the entry point, starts and all direct call targets reachable from them become
+// sub_<addr> symbols reaching up to the next function.
+func (s *Binary) DiscoverFunctions(starts []uint64, known map[ds.Range]*ds.Symbol) map[ds.Range]*ds.Symbol {
+	res := make(map[ds.Range]*ds.Symbol)
+	bounds := make(map[uint64]ds.Range)
+	worklist := append([]uint64{s.Entry}, starts...)
+	for rng, symbol := range known {
+		worklist = append(worklist, rng.From)
+		if !rng.IsEmpty() {
+			res[rng] = symbol
+			bounds[rng.From] = rng
+		}
+	}
+
+	found := make(map[uint64]bool)
+	for len(worklist) > 0 {
+		addr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		addr = s.functionStart(addr)
+		if _, stub := s.PLT[addr]; found[addr] || stub {
+			continue
+		}
+		region := s.codeRegionAt(addr)
+		if region == nil {
+			continue
+		}
+		found[addr] = true
+		rng, bounded := bounds[addr]
+		if !bounded {
+			rng = ds.NewRange(addr, functionEnd(addr, region, found))
+		}
+		if code_end := region.Range.From + uint64(len(region.Data)); rng.To > code_end {
+			rng = ds.NewRange(addr, code_end)
+		}
+		worklist = append(worklist, disassemble.GetCallTargets(region.Range.From, region.Data, rng, &disassemble.Context{Arch: s.ArchAt(addr)})...)
+	}
+
+	addrs := make([]uint64, 0, len(found))
+	for addr := range found {
+		addrs = append(addrs, addr)
+	}
+	sort.Sort(uint64Array(addrs))
+	for _, addr := range addrs {
+		if _, bounded := bounds[addr]; bounded || insideKnownFunction(addr, bounds) {
+			continue
+		}
+		rng := ds.NewRange(addr, functionEnd(addr, s.codeRegionAt(addr), found))
+		res[rng] = ds.NewSymbol(fmt.Sprintf("sub_%x", addr), ds.FUNC)
+	}
+	return res
+}
+
+func insideKnownFunction(addr uint64, bounds map[uint64]ds.Range) bool {
+	for _, rng := range bounds {
+		if rng.From < addr && addr < rng.To {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package elf
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"sort"
+)
+
+// origin is the part of a subprogram that is shared by its out of line copy and all the places it was inlined into
+type origin struct {
+	name string
+	file string
+	line int
+}
+
+func unitFiles(d *dwarf.Data, unit *dwarf.Entry) []*dwarf.LineFile {
+	lines, err := d.LineReader(unit)
+	if err != nil || lines == nil {
+		return nil
+	}
+	return lines.Files()
+}
+
+// unit is a compilation unit with the file table its DW_AT_decl_file attributes index
+type unit struct {
+	offset dwarf.Offset
+	files  []*dwarf.LineFile
+}
+
+// readUnits returns the compilation units of d ordered by offset
+func readUnits(d *dwarf.Data) []unit {
+	res := make([]unit, 0)
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			return res
+		}
+		if entry.Tag == dwarf.TagCompileUnit || entry.Tag == dwarf.TagPartialUnit {
+			res = append(res, unit{offset: entry.Offset, files: unitFiles(d, entry)})
+		}
+		r.SkipChildren()
+	}
+}
+
+// filesAt returns the file table of the compilation unit containing the entry at offset. References with
+// DW_FORM_ref_addr, as link time optimization emits them, lead into other units than the one being read.
+func filesAt(units []unit, offset dwarf.Offset) []*dwarf.LineFile {
+	i := sort.Search(len(units), func(i int) bool { return units[i].offset > offset })
+	if i == 0 {
+		return nil
+	}
+	return units[i-1].files
+}
+
+func readOrigin(entry *dwarf.Entry, files []*dwarf.LineFile) origin {
+	res := origin{}
+	if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+		res.name = name
+	}
+	if index, ok := entry.Val(dwarf.AttrDeclFile).(int64); ok && index >= 0 && int(index) < len(files) && files[index] != nil {
+		res.file = files[index].Name
+	}
+	if line, ok := entry.Val(dwarf.AttrDeclLine).(int64); ok {
+		res.line = int(line)
+	}
+	return res
+}
+
+// lookupOrigin follows DW_AT_abstract_origin and DW_AT_specification to the entry that carries name and declaration
+func lookupOrigin(d *dwarf.Data, entry *dwarf.Entry, units []unit, cache map[dwarf.Offset]origin) origin {
+	res := readOrigin(entry, filesAt(units, entry.Offset))
+	for _, attr := range []dwarf.Attr{dwarf.AttrAbstractOrigin, dwarf.AttrSpecification} {
+		offset, ok := entry.Val(attr).(dwarf.Offset)
+		if !ok {
+			continue
+		}
+		ref, cached := cache[offset]
+		if !cached {
+			r := d.Reader()
+			r.Seek(offset)
+			target, err := r.Next()
+			if err != nil || target == nil {
+				continue
+			}
+			cache[offset] = origin{} //malformed references can form a cycle, it ends at this placeholder
+			ref = lookupOrigin(d, target, units, cache)
+			cache[offset] = ref
+		}
+		if res.name == "" {
+			res.name = ref.name
+		}
+		if res.file == "" {
+			res.file, res.line = ref.file, ref.line
+		}
+	}
+	return res
+}
+
+func newDebugSymbol(org origin) *ds.Symbol {
+	symbol := ds.NewSymbol(org.name, ds.FUNC)
+	symbol.File = org.file
+	symbol.Line = org.line
+	return symbol
+}
+
+// GetDWARFSymbols returns a FUNC symbol for every range of code of a DW_TAG_subprogram, carrying the declaration and
+// the inlined subroutines found within. Binaries without debug information yield no symbols.
+func GetDWARFSymbols(e *elf.File, base uint64) map[ds.Range]*ds.Symbol {
+	res := make(map[ds.Range]*ds.Symbol)
+	base = LoadBase(e, base)
+	d, err := e.DWARF()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Info("No DWARF information")
+		return res
+	}
+
+	cache := make(map[dwarf.Offset]origin)
+	units := readUnits(d)
+	var functions []*ds.Symbol //innermost enclosing subprogram for every open level of children, nil if none
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Info("Failed to Parse DWARF")
+			return res
+		}
+		if entry == nil {
+			return res
+		}
+		if entry.Tag == 0 {
+			if len(functions) > 0 {
+				functions = functions[:len(functions)-1]
+			}
+			continue
+		}
+
+		var enclosing *ds.Symbol
+		if len(functions) > 0 {
+			enclosing = functions[len(functions)-1]
+		}
+		current := enclosing
+
+		switch entry.Tag {
+		case dwarf.TagCompileUnit, dwarf.TagPartialUnit:
+			current = nil
+		case dwarf.TagSubprogram:
+			ranges, _ := d.Ranges(entry)
+			if len(ranges) == 0 {
+				break
+			}
+			org := lookupOrigin(d, entry, units, cache)
+			symbol := newDebugSymbol(org)
+			symbol.Inlined = make(map[ds.Range]*ds.Symbol)
+			for _, rng := range ranges {
+				if rng[0] < rng[1] {
+					res[ds.NewRange(base+rng[0], base+rng[1])] = symbol
+				}
+			}
+			current = symbol
+		case dwarf.TagInlinedSubroutine:
+			ranges, _ := d.Ranges(entry)
+			if enclosing == nil || len(ranges) == 0 {
+				break
+			}
+			inlined := newDebugSymbol(lookupOrigin(d, entry, units, cache))
+			for _, rng := range ranges {
+				if rng[0] < rng[1] {
+					enclosing.Inlined[ds.NewRange(base+rng[0], base+rng[1])] = inlined
+				}
+			}
+		}
+
+		if entry.Children {
+			functions = append(functions, current)
+		}
+	}
+}
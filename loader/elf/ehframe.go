@@ -0,0 +1,492 @@
+package elf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+)
+
+// pointer encodings used in .eh_frame (DW_EH_PE_*)
+const (
+	DW_EH_PE_absptr  = 0x00
+	DW_EH_PE_uleb128 = 0x01
+	DW_EH_PE_udata2  = 0x02
+	DW_EH_PE_udata4  = 0x03
+	DW_EH_PE_udata8  = 0x04
+	DW_EH_PE_sleb128 = 0x09
+	DW_EH_PE_sdata2  = 0x0a
+	DW_EH_PE_sdata4  = 0x0b
+	DW_EH_PE_sdata8  = 0x0c
+	DW_EH_PE_pcrel   = 0x10
+	DW_EH_PE_datarel = 0x30
+	DW_EH_PE_omit    = 0xff
+)
+
+// call frame instructions (DW_CFA_*), the first three carry an operand in their lower 6 bits
+const (
+	DW_CFA_advance_loc                  = 0x40
+	DW_CFA_offset                       = 0x80
+	DW_CFA_restore                      = 0xc0
+	DW_CFA_nop                          = 0x00
+	DW_CFA_set_loc                      = 0x01
+	DW_CFA_advance_loc1                 = 0x02
+	DW_CFA_advance_loc2                 = 0x03
+	DW_CFA_advance_loc4                 = 0x04
+	DW_CFA_offset_extended              = 0x05
+	DW_CFA_restore_extended             = 0x06
+	DW_CFA_undefined                    = 0x07
+	DW_CFA_same_value                   = 0x08
+	DW_CFA_register                     = 0x09
+	DW_CFA_remember_state               = 0x0a
+	DW_CFA_restore_state                = 0x0b
+	DW_CFA_def_cfa                      = 0x0c
+	DW_CFA_def_cfa_register             = 0x0d
+	DW_CFA_def_cfa_offset               = 0x0e
+	DW_CFA_def_cfa_expression           = 0x0f
+	DW_CFA_expression                   = 0x10
+	DW_CFA_offset_extended_sf           = 0x11
+	DW_CFA_def_cfa_sf                   = 0x12
+	DW_CFA_def_cfa_offset_sf            = 0x13
+	DW_CFA_val_offset                   = 0x14
+	DW_CFA_val_offset_sf                = 0x15
+	DW_CFA_val_expression               = 0x16
+	DW_CFA_GNU_args_size                = 0x2e
+	DW_CFA_GNU_negative_offset_extended = 0x2f
+)
+
+// CIE holds the information shared by several FDEs
+type CIE struct {
+	CodeAlign      uint64
+	DataAlign      int64
+	ReturnRegister uint64
+	Encoding       byte //encoding of the addresses in the FDEs
+	Instructions   []byte
+	augmented      bool //the FDEs carry augmentation data
+	order          binary.ByteOrder
+	ptrSize        int
+}
+
+// FDE describes how to unwind the stack within Range
+type FDE struct {
+	CIE          *CIE
+	Range        ds.Range
+	Instructions []byte
+	instr_addr   uint64 //load address of Instructions[0]
+}
+
+// CFARule locates the canonical frame address (the stack pointer before the call): it is the value of Register (DWARF
+// numbering) plus Offset. Rules given as DWARF expression can't be evaluated, they only set Expression
+type CFARule struct {
+	Register   uint64
+	Offset     int64
+	Expression bool
+}
+
+// CFARow is the rule that applies from Addr up to the next row
+type CFARow struct {
+	Addr uint64
+	Rule CFARule
+}
+
+// cursor reads the fields of .eh_frame, addr is the address of data[0] at load time. Fixed size fields are stored in
+// the byte order of the file, absolute pointers have the pointer size of the file.
+type cursor struct {
+	data    []byte
+	pos     int
+	addr    uint64
+	order   binary.ByteOrder
+	ptrSize int
+	err     error
+}
+
+func (c *cursor) bytes(n int) []byte {
+	if c.err != nil || n < 0 || c.pos+n > len(c.data) {
+		c.err = fmt.Errorf("truncated .eh_frame at offset %x", c.pos)
+		return make([]byte, 8) //large enough for every fixed size field
+
+	}
+	res := c.data[c.pos : c.pos+n]
+	c.pos += n
+	return res
+}
+
+func (c *cursor) u8() byte {
+	return c.bytes(1)[0]
+}
+
+func (c *cursor) uleb() uint64 {
+	res, shift := uint64(0), uint(0)
+	for {
+		b := c.u8()
+		if c.err != nil {
+			return 0
+		}
+		res |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return res
+		}
+	}
+}
+
+func (c *cursor) sleb() int64 {
+	res, shift := int64(0), uint(0)
+	for {
+		b := c.u8()
+		if c.err != nil {
+			return 0
+		}
+		res |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				res |= -1 << shift
+			}
+			return res
+		}
+	}
+}
+
+func (c *cursor) cstring() string {
+	start := c.pos
+	for c.err == nil && c.u8() != 0 {
+	}
+	if c.err != nil {
+		return ""
+	}
+	return string(c.data[start : c.pos-1])
+}
+
+// pointer decodes a value with the given DW_EH_PE_* encoding
+func (c *cursor) pointer(encoding byte) uint64 {
+	if encoding == DW_EH_PE_omit {
+		return 0
+	}
+	field := c.addr + uint64(c.pos)
+	var res uint64
+	switch encoding & 0x0f {
+	case DW_EH_PE_absptr:
+		if c.ptrSize == 4 {
+			res = uint64(c.order.Uint32(c.bytes(4)))
+		} else {
+			res = c.order.Uint64(c.bytes(8))
+		}
+	case DW_EH_PE_udata8, DW_EH_PE_sdata8:
+		res = c.order.Uint64(c.bytes(8))
+	case DW_EH_PE_uleb128:
+		res = c.uleb()
+	case DW_EH_PE_sleb128:
+		res = uint64(c.sleb())
+	case DW_EH_PE_udata2:
+		res = uint64(c.order.Uint16(c.bytes(2)))
+	case DW_EH_PE_sdata2:
+		res = uint64(int16(c.order.Uint16(c.bytes(2))))
+	case DW_EH_PE_udata4:
+		res = uint64(c.order.Uint32(c.bytes(4)))
+	case DW_EH_PE_sdata4:
+		res = uint64(int32(c.order.Uint32(c.bytes(4))))
+	default:
+		c.err = fmt.Errorf("unsupported pointer encoding %x", encoding)
+	}
+	if encoding&0x70 == DW_EH_PE_pcrel {
+		res += field
+	}
+	if encoding&0x70 == DW_EH_PE_datarel { //only used by .eh_frame_hdr, relative to its start
+		res += c.addr
+	}
+	return res
+}
+
+func parseCIE(c *cursor) (*CIE, error) {
+	cie := &CIE{Encoding: DW_EH_PE_absptr, order: c.order, ptrSize: c.ptrSize}
+	version := c.u8()
+	augmentation := c.cstring()
+	cie.CodeAlign = c.uleb()
+	cie.DataAlign = c.sleb()
+	if version == 1 {
+		cie.ReturnRegister = uint64(c.u8())
+	} else {
+		cie.ReturnRegister = c.uleb()
+	}
+	if len(augmentation) > 0 && augmentation[0] == 'z' {
+		cie.augmented = true
+		length := c.uleb()
+		if c.err == nil && length > uint64(len(c.data)-c.pos) {
+			c.err = fmt.Errorf("invalid augmentation length %x at offset %x", length, c.pos)
+			return cie, c.err
+		}
+		end := c.pos + int(length)
+		for _, aug := range augmentation[1:] {
+			switch aug {
+			case 'R':
+				cie.Encoding = c.u8()
+			case 'P':
+				c.pointer(c.u8() &^ 0x80)
+			case 'L':
+				c.u8()
+			}
+		}
+		c.pos = end
+	}
+	cie.Instructions = c.data[c.pos:]
+	return cie, c.err
+}
+
+// ParseEHFrame decodes the CIEs and FDEs in the content of an .eh_frame section loaded at addr, of a file with the
+// given byte order and pointer size
+func ParseEHFrame(data []byte, addr uint64, order binary.ByteOrder, ptrSize int) ([]*FDE, error) {
+	res := make([]*FDE, 0)
+	cies := make(map[int]*CIE)
+	pos := 0
+	for pos+4 <= len(data) {
+		start := pos
+		length := uint64(order.Uint32(data[pos:]))
+		pos += 4
+		if length == 0 { //terminator
+			break
+		}
+		if length == 0xffffffff {
+			if pos+8 > len(data) {
+				return res, fmt.Errorf("truncated .eh_frame at offset %x", start)
+			}
+			length = order.Uint64(data[pos:])
+			pos += 8
+		}
+		if length > uint64(len(data)-pos) || length < 4 {
+			return res, fmt.Errorf("invalid entry length %x at offset %x", length, start)
+		}
+		end := pos + int(length)
+		id_pos := pos
+		id := order.Uint32(data[pos:])
+		c := &cursor{data: data[:end], pos: pos + 4, addr: addr, order: order, ptrSize: ptrSize}
+
+		if id == 0 {
+			cie, err := parseCIE(c)
+			if err != nil {
+				return res, err
+			}
+			cies[start] = cie
+		} else {
+			cie, ok := cies[id_pos-int(id)]
+			if !ok {
+				return res, fmt.Errorf("FDE at offset %x refers to unknown CIE", start)
+			}
+			from := c.pointer(cie.Encoding)
+			size := c.pointer(cie.Encoding & 0x0f)
+			if cie.augmented {
+				c.bytes(int(c.uleb()))
+			}
+			if c.err != nil {
+				return res, c.err
+			}
+			fde := &FDE{CIE: cie, Range: ds.NewRange(from, from+size), Instructions: c.data[c.pos:], instr_addr: addr + uint64(c.pos)}
+			res = append(res, fde)
+		}
+		pos = end
+	}
+	return res, nil
+}
+
+// runCFA executes call frame instructions, starting with rule at loc. Every advance of the location adds a row
+func runCFA(c *cursor, cie *CIE, loc uint64, rule CFARule) []CFARow {
+	rows := []CFARow{{Addr: loc, Rule: rule}}
+	stack := make([]CFARule, 0)
+	advance := func(delta uint64) {
+		loc += delta * cie.CodeAlign
+		rows = append(rows, CFARow{Addr: loc, Rule: rule})
+	}
+	set := func(r CFARule) {
+		rule = r
+		rows[len(rows)-1].Rule = rule
+	}
+
+	for c.err == nil && c.pos < len(c.data) {
+		op := c.u8()
+		switch op & 0xc0 {
+		case DW_CFA_advance_loc:
+			advance(uint64(op & 0x3f))
+			continue
+		case DW_CFA_offset:
+			c.uleb()
+			continue
+		case DW_CFA_restore:
+			continue
+		}
+		switch op {
+		case DW_CFA_nop:
+		case DW_CFA_set_loc:
+			loc = c.pointer(cie.Encoding)
+			advance(0)
+		case DW_CFA_advance_loc1:
+			advance(uint64(c.u8()))
+		case DW_CFA_advance_loc2:
+			advance(uint64(c.order.Uint16(c.bytes(2))))
+		case DW_CFA_advance_loc4:
+			advance(uint64(c.order.Uint32(c.bytes(4))))
+		case DW_CFA_offset_extended, DW_CFA_register, DW_CFA_val_offset:
+			c.uleb()
+			c.uleb()
+		case DW_CFA_offset_extended_sf, DW_CFA_val_offset_sf, DW_CFA_GNU_negative_offset_extended:
+			c.uleb()
+			c.sleb()
+		case DW_CFA_restore_extended, DW_CFA_undefined, DW_CFA_same_value, DW_CFA_GNU_args_size:
+			c.uleb()
+		case DW_CFA_remember_state:
+			stack = append(stack, rule)
+		case DW_CFA_restore_state:
+			if len(stack) > 0 {
+				set(stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+		case DW_CFA_def_cfa:
+			reg := c.uleb()
+			set(CFARule{Register: reg, Offset: int64(c.uleb())})
+		case DW_CFA_def_cfa_sf:
+			reg := c.uleb()
+			set(CFARule{Register: reg, Offset: c.sleb() * cie.DataAlign})
+		case DW_CFA_def_cfa_register:
+			set(CFARule{Register: c.uleb(), Offset: rule.Offset})
+		case DW_CFA_def_cfa_offset:
+			set(CFARule{Register: rule.Register, Offset: int64(c.uleb())})
+		case DW_CFA_def_cfa_offset_sf:
+			set(CFARule{Register: rule.Register, Offset: c.sleb() * cie.DataAlign})
+		case DW_CFA_def_cfa_expression:
+			c.bytes(int(c.uleb()))
+			set(CFARule{Expression: true})
+		case DW_CFA_expression, DW_CFA_val_expression:
+			c.uleb()
+			c.bytes(int(c.uleb()))
+		default:
+			c.err = fmt.Errorf("unknown call frame instruction %x", op)
+		}
+	}
+	return rows
+}
+
+// CFARows evaluates the call frame instructions of the CIE and the FDE. The rows are sorted by address, the first one
+// starts at Range.From and every row differs from its predecessor
+func (f *FDE) CFARows() ([]CFARow, error) {
+	cie_cursor := &cursor{data: f.CIE.Instructions, order: f.CIE.order, ptrSize: f.CIE.ptrSize}
+	initial := runCFA(cie_cursor, f.CIE, f.Range.From, CFARule{})
+	fde_cursor := &cursor{data: f.Instructions, addr: f.instr_addr, order: f.CIE.order, ptrSize: f.CIE.ptrSize}
+	rows := runCFA(fde_cursor, f.CIE, f.Range.From, initial[len(initial)-1].Rule)
+
+	res := make([]CFARow, 0, len(rows))
+	for _, row := range rows {
+		if len(res) > 0 && res[len(res)-1].Addr == row.Addr {
+			res[len(res)-1] = row
+		} else if len(res) == 0 || res[len(res)-1].Rule != row.Rule {
+			res = append(res, row)
+		}
+	}
+	if cie_cursor.err != nil {
+		return res, cie_cursor.err
+	}
+	return res, fde_cursor.err
+}
+
+// CFAAt returns the rule that locates the canonical frame address at addr
+func (f *FDE) CFAAt(addr uint64) (CFARule, bool) {
+	if !(f.Range.From <= addr && addr < f.Range.To) {
+		return CFARule{}, false
+	}
+	rows, err := f.CFARows()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "fde": f.Range}).Info("Failed to evaluate call frame instructions")
+	}
+	res, ok := CFARule{}, false
+	for _, row := range rows {
+		if row.Addr > addr {
+			break
+		}
+		res, ok = row.Rule, true
+	}
+	return res, ok
+}
+
+// EHFrameHdr is the content of .eh_frame_hdr, which is referenced by the PT_GNU_EH_FRAME segment
+type EHFrameHdr struct {
+	EHFrame uint64            //address of .eh_frame
+	Table   map[uint64]uint64 //start of a function -> address of its FDE
+}
+
+// ParseEHFrameHdr decodes the content of an .eh_frame_hdr section loaded at addr, see ParseEHFrame
+func ParseEHFrameHdr(data []byte, addr uint64, order binary.ByteOrder, ptrSize int) (*EHFrameHdr, error) {
+	c := &cursor{data: data, addr: addr, order: order, ptrSize: ptrSize}
+	if version := c.u8(); version != 1 {
+		return nil, fmt.Errorf("unsupported .eh_frame_hdr version %d", version)
+	}
+	frame_enc, count_enc, table_enc := c.u8(), c.u8(), c.u8()
+	res := &EHFrameHdr{EHFrame: c.pointer(frame_enc), Table: make(map[uint64]uint64)}
+	if count_enc == DW_EH_PE_omit || table_enc == DW_EH_PE_omit {
+		return res, c.err
+	}
+	count := c.pointer(count_enc)
+	for i := uint64(0); i < count && c.err == nil; i++ {
+		start := c.pointer(table_enc)
+		res.Table[start] = c.pointer(table_enc)
+	}
+	return res, c.err
+}
+
+func segmentData(prog *elf.Prog) ([]byte, error) {
+	data := make([]byte, prog.Filesz)
+	if _, err := prog.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// findEHFrame locates .eh_frame by the PT_GNU_EH_FRAME segment, for files without section headers. It returns the
+// content of the loadable segment from the start of .eh_frame on and its link time address
+func findEHFrame(e *elf.File) ([]byte, uint64, error) {
+	for _, prog := range e.Progs {
+		if prog.Type != elf.PT_GNU_EH_FRAME {
+			continue
+		}
+		data, err := segmentData(prog)
+		if err != nil {
+			return nil, 0, err
+		}
+		hdr, err := ParseEHFrameHdr(data, prog.Vaddr, e.ByteOrder, int(pointerSize(e)))
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, load := range e.Progs {
+			if load.Type == elf.PT_LOAD && load.Vaddr <= hdr.EHFrame && hdr.EHFrame < load.Vaddr+load.Filesz {
+				data, err := segmentData(load)
+				if err != nil {
+					return nil, 0, err
+				}
+				return data[hdr.EHFrame-load.Vaddr:], hdr.EHFrame, nil
+			}
+		}
+		return nil, 0, fmt.Errorf(".eh_frame at %x is not loaded", hdr.EHFrame)
+	}
+	return nil, 0, fmt.Errorf("no .eh_frame")
+}
+
+// GetFDEs parses the .eh_frame section of e, the ranges are at load time addresses
+func GetFDEs(e *elf.File, base uint64) []*FDE {
+	base = LoadBase(e, base)
+	var data []byte
+	var addr uint64
+	var err error
+	if sec := e.Section(".eh_frame"); sec != nil && sec.Type != elf.SHT_NOBITS {
+		addr = sec.Addr
+		data, err = sec.Data()
+	} else {
+		data, addr, err = findEHFrame(e)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Info("Failed to read .eh_frame")
+		return []*FDE{}
+	}
+	fdes, err := ParseEHFrame(data, base+addr, e.ByteOrder, int(pointerSize(e)))
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Info("Failed to parse .eh_frame")
+	}
+	return fdes
+}
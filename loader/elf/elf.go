@@ -2,10 +2,12 @@ package elf
 
 import (
 	"debug/elf"
+	"encoding/binary"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	ds "github.com/ranmrdrakono/indika/data_structures"
 	"io"
+	"math/bits"
 	"os"
 )
 
@@ -36,24 +38,61 @@ func elfFlagsToPageFlags(in elf.ProgFlag) ds.PageFlags {
 	return res
 }
 
+// DefaultLoadBase is where position independent executables and shared objects are mapped, the same address gdb uses
+const DefaultLoadBase = 0x555555554000
+
+// DefaultLoadBase32 is where position independent 32 bit files are mapped, if the base they are opened at does not fit
+// into their address space
+const DefaultLoadBase32 = 0x56555000
+
+// LoadBase returns the offset between link time and load time addresses, only position independent files can be moved
+func LoadBase(e *elf.File, base uint64) uint64 {
+	if e.Type != elf.ET_DYN {
+		return 0
+	}
+	if e.Class == elf.ELFCLASS32 && base > 0xffffffff {
+		return DefaultLoadBase32
+	}
+	return base
+}
+
+func pointerSize(e *elf.File) uint64 {
+	if e.Class == elf.ELFCLASS32 {
+		return 4
+	}
+	return 8
+}
+
 func GetSegments(e *elf.File) map[ds.Range]*ds.MappedRegion {
+	return GetSegmentsAt(e, 0)
+}
+
+// maxSegmentSize bounds the memory size of a segment, larger sizes only occur in malformed files
+const maxSegmentSize = 1 << 30
+
+func GetSegmentsAt(e *elf.File, base uint64) map[ds.Range]*ds.MappedRegion {
 	res := make(map[ds.Range]*ds.MappedRegion)
+	base = LoadBase(e, base)
 	for _, prog_offset := range e.Progs {
 		hdr := prog_offset.ProgHeader
 		if hdr.Off == 0 && hdr.Filesz == 0 {
 			continue
 		}
+		if hdr.Filesz > hdr.Memsz || hdr.Memsz > maxSegmentSize {
+			log.WithFields(log.Fields{"vaddr": hdr.Vaddr, "filesz": hdr.Filesz, "memsz": hdr.Memsz}).Info("Skipping Invalid Segment")
+			continue
+		}
 		info := new(ds.MappedRegion)
-		info.Range = ds.NewRange(hdr.Vaddr, hdr.Vaddr+hdr.Memsz)
-		info.Data = make([]byte, hdr.Filesz, hdr.Filesz)
+		info.Range = ds.NewRange(base+hdr.Vaddr, base+hdr.Vaddr+hdr.Memsz)
+		// the part beyond Filesz (.bss) is zero initialized
+		info.Data = make([]byte, hdr.Memsz, hdr.Memsz)
 		info.Flags = elfFlagsToPageFlags(hdr.Flags)
 		info.Loaded = (hdr.Type == elf.PT_LOAD)
-		res[info.Range] = info
-		size_read, err := prog_offset.Open().Read(info.Data)
-		check(err)
-		if uint64(size_read) != hdr.Filesz {
-			panic("size missmatch")
+		if _, err := io.ReadFull(prog_offset.Open(), info.Data[:hdr.Filesz]); err != nil {
+			log.WithFields(log.Fields{"vaddr": hdr.Vaddr, "error": err}).Info("Skipping Truncated Segment")
+			continue
 		}
+		res[info.Range] = info
 	}
 	return res
 }
@@ -89,8 +128,52 @@ func elfSymbolTypeToSymbolType(elfsymbol uint) ds.SymbolType {
 	return ds.UNKNOWN
 }
 
+// symbolAddress returns the load address of sym. The lowest bit of the value of an ARM function selects Thumb code, it
+// is no part of the address.
+func symbolAddress(e *elf.File, sym elf.Symbol, base uint64) uint64 {
+	if e.Machine == elf.EM_ARM && elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+		return base + sym.Value&^1
+	}
+	return base + sym.Value
+}
+
+// GetEntryAt returns the load address of the entry point of e mapped at base
+func GetEntryAt(e *elf.File, base uint64) uint64 {
+	base = LoadBase(e, base)
+	if e.Machine == elf.EM_ARM {
+		return base + e.Entry&^1
+	}
+	return base + e.Entry
+}
+
+// GetThumbFunctions returns the load addresses of the functions of an ARM file that are Thumb code, as marked by the
+// lowest bit of their symbol values and the entry point
+func GetThumbFunctions(e *elf.File, base uint64) map[uint64]bool {
+	res := make(map[uint64]bool)
+	if e.Machine != elf.EM_ARM {
+		return res
+	}
+	base = LoadBase(e, base)
+	if e.Entry&1 != 0 {
+		res[base+e.Entry&^1] = true
+	}
+	symbols, _ := e.Symbols()
+	dynamic, _ := e.DynamicSymbols()
+	for _, sym := range append(symbols, dynamic...) {
+		if sym.Section != elf.SHN_UNDEF && elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value&1 != 0 {
+			res[symbolAddress(e, sym, base)] = true
+		}
+	}
+	return res
+}
+
 func GetSymbols(e *elf.File) map[ds.Range]*ds.Symbol {
+	return GetSymbolsAt(e, 0)
+}
+
+func GetSymbolsAt(e *elf.File, base uint64) map[ds.Range]*ds.Symbol {
 	res := make(map[ds.Range]*ds.Symbol)
+	base = LoadBase(e, base)
 	symbols, err := e.Symbols()
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Info("Failed to Parse Symbols")
@@ -99,7 +182,507 @@ func GetSymbols(e *elf.File) map[ds.Range]*ds.Symbol {
 	for _, sym := range symbols {
 		sym_type := elfSymbolTypeToSymbolType(uint(sym.Info))
 		symbol := ds.NewSymbol(sym.Name, sym_type)
-		res[ds.NewRange(sym.Value, sym.Value+sym.Size)] = symbol
+		addr := symbolAddress(e, sym, base)
+		res[ds.NewRange(addr, addr+sym.Size)] = symbol
+	}
+	return res
+}
+
+func regionFor(maps map[ds.Range]*ds.MappedRegion, addr uint64, size uint64) *ds.MappedRegion {
+	for rng, region := range maps {
+		if region.Loaded && rng.From <= addr && addr+size <= rng.From+uint64(len(region.Data)) {
+			return region
+		}
+	}
+	return nil
+}
+
+func writePointer(e *elf.File, maps map[ds.Range]*ds.MappedRegion, addr uint64, val uint64) bool {
+	region := regionFor(maps, addr, pointerSize(e))
+	if region == nil {
+		return false
+	}
+	if pointerSize(e) == 4 {
+		e.ByteOrder.PutUint32(region.Data[addr-region.Range.From:], uint32(val))
+	} else {
+		e.ByteOrder.PutUint64(region.Data[addr-region.Range.From:], val)
+	}
+	return true
+}
+
+// resolveSymbol returns the load address of the dynamic symbol with the given index and whether it is imported from
+// another object. Imports resolve to ds.ImportAddress, unresolved weak imports to 0 just as the dynamic linker does
+func resolveSymbol(e *elf.File, symbols []elf.Symbol, index uint32, base uint64) (uint64, string, bool) {
+	if index == 0 || int(index) > len(symbols) {
+		return 0, "", false
+	}
+	sym := symbols[index-1]
+	if sym.Section != elf.SHN_UNDEF {
+		return base + sym.Value, sym.Name, false
+	}
+	if elf.ST_BIND(sym.Info) == elf.STB_WEAK {
+		return 0, sym.Name, false
+	}
+	return ds.ImportAddress(sym.Name, int(pointerSize(e))), sym.Name, true
+}
+
+// relocation types with the same meaning on all supported machines
+const (
+	relocOther    = iota
+	relocRelative //base + addend
+	relocImport   //address of a symbol, GLOB_DAT and JUMP_SLOT
+	relocAbsolute //address of a symbol + addend
+)
+
+// mipsJumpSlot is R_MIPS_JUMP_SLOT, which debug/elf does not define
+const mipsJumpSlot = elf.R_MIPS(127)
+
+func supportedMachine(e *elf.File) bool {
+	switch e.Machine {
+	case elf.EM_X86_64, elf.EM_386, elf.EM_AARCH64, elf.EM_ARM, elf.EM_PPC:
+		return true
+	case elf.EM_MIPS:
+		return e.Class == elf.ELFCLASS32
+	case elf.EM_RISCV:
+		return e.Class == elf.ELFCLASS64
+	}
+	return false
+}
+
+func relocationKind(e *elf.File, rel relocation) int {
+	switch e.Machine {
+	case elf.EM_X86_64:
+		switch elf.R_X86_64(rel.typ) {
+		case elf.R_X86_64_RELATIVE:
+			return relocRelative
+		case elf.R_X86_64_GLOB_DAT, elf.R_X86_64_JMP_SLOT:
+			return relocImport
+		case elf.R_X86_64_64:
+			return relocAbsolute
+		}
+	case elf.EM_386:
+		switch elf.R_386(rel.typ) {
+		case elf.R_386_RELATIVE:
+			return relocRelative
+		case elf.R_386_GLOB_DAT, elf.R_386_JMP_SLOT:
+			return relocImport
+		case elf.R_386_32:
+			return relocAbsolute
+		}
+	case elf.EM_AARCH64:
+		switch elf.R_AARCH64(rel.typ) {
+		case elf.R_AARCH64_RELATIVE:
+			return relocRelative
+		case elf.R_AARCH64_GLOB_DAT, elf.R_AARCH64_JUMP_SLOT:
+			return relocImport
+		case elf.R_AARCH64_ABS64:
+			return relocAbsolute
+		}
+	case elf.EM_ARM:
+		switch elf.R_ARM(rel.typ) {
+		case elf.R_ARM_RELATIVE:
+			return relocRelative
+		case elf.R_ARM_GLOB_DAT, elf.R_ARM_JUMP_SLOT:
+			return relocImport
+		case elf.R_ARM_ABS32:
+			return relocAbsolute
+		}
+	case elf.EM_MIPS:
+		switch elf.R_MIPS(rel.typ) {
+		case elf.R_MIPS_REL32: //relative without symbol, symbol + addend otherwise
+			if rel.symbol == 0 {
+				return relocRelative
+			}
+			return relocAbsolute
+		case mipsJumpSlot:
+			return relocImport
+		case elf.R_MIPS_32:
+			return relocAbsolute
+		}
+	case elf.EM_PPC:
+		switch elf.R_PPC(rel.typ) {
+		case elf.R_PPC_RELATIVE:
+			return relocRelative
+		case elf.R_PPC_GLOB_DAT, elf.R_PPC_JMP_SLOT:
+			return relocImport
+		case elf.R_PPC_ADDR32:
+			return relocAbsolute
+		}
+	case elf.EM_RISCV:
+		switch elf.R_RISCV(rel.typ) {
+		case elf.R_RISCV_RELATIVE:
+			return relocRelative
+		case elf.R_RISCV_JUMP_SLOT:
+			return relocImport
+		case elf.R_RISCV_64:
+			return relocAbsolute
+		}
+	}
+	return relocOther
+}
+
+// relocation is a single entry of a dynamic relocation section
+type relocation struct {
+	offset   uint64 //link time address of the patched slot
+	typ      uint32
+	symbol   uint32 //index into the dynamic symbol table, 0 for none
+	addend   uint64
+	implicit bool //SHT_REL entries have no addend field, the addend is the initial content of the slot
+}
+
+func parseRelocation(e *elf.File, data []byte, implicit bool) relocation {
+	rel := relocation{implicit: implicit}
+	if e.Class == elf.ELFCLASS32 {
+		info := e.ByteOrder.Uint32(data[4:])
+		rel.offset, rel.typ, rel.symbol = uint64(e.ByteOrder.Uint32(data)), elf.R_TYPE32(info), elf.R_SYM32(info)
+		if !implicit {
+			rel.addend = uint64(e.ByteOrder.Uint32(data[8:]))
+		}
+		return rel
+	}
+	info := e.ByteOrder.Uint64(data[8:])
+	rel.offset, rel.typ, rel.symbol = e.ByteOrder.Uint64(data), elf.R_TYPE64(info), elf.R_SYM64(info)
+	if !implicit {
+		rel.addend = e.ByteOrder.Uint64(data[16:])
+	}
+	return rel
+}
+
+// relocations returns the entries of all allocated SHT_RELA and SHT_REL sections of e in file order, followed by the
+// implicit relocations of the MIPS GOT
+func relocations(e *elf.File) []relocation {
+	res := make([]relocation, 0)
+	for _, sec := range e.Sections {
+		if sec.Type != elf.SHT_RELA && sec.Type != elf.SHT_REL || sec.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "section": sec.Name}).Info("Failed to Read Relocations")
+			continue
+		}
+		implicit := sec.Type == elf.SHT_REL
+		size := 3 * int(pointerSize(e)) //offset, info and addend
+		if implicit {
+			size -= int(pointerSize(e))
+		}
+		for i := 0; i+size <= len(data); i += size {
+			res = append(res, parseRelocation(e, data[i:], implicit))
+		}
+	}
+	if e.Machine == elf.EM_MIPS {
+		res = append(res, mipsGOTRelocations(e)...)
+	}
+	return res
+}
+
+// dynamicValue returns the value of the first entry with the given tag in the .dynamic section
+func dynamicValue(e *elf.File, tag elf.DynTag) (uint64, bool) {
+	sec := e.Section(".dynamic")
+	if sec == nil {
+		return 0, false
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return 0, false
+	}
+	size := int(pointerSize(e))
+	for i := 0; i+2*size <= len(data); i += 2 * size {
+		if size == 4 && elf.DynTag(e.ByteOrder.Uint32(data[i:])) == tag {
+			return uint64(e.ByteOrder.Uint32(data[i+4:])), true
+		}
+		if size == 8 && elf.DynTag(e.ByteOrder.Uint64(data[i:])) == tag {
+			return e.ByteOrder.Uint64(data[i+8:]), true
+		}
+	}
+	return 0, false
+}
+
+// mipsGOTRelocations describes the MIPS GOT, which the dynamic linker fills in without relocation entries. The local
+// entries are rebased like R_MIPS_REL32 without symbol, the entries of the global symbols from DT_MIPS_GOTSYM on get
+// the address of their symbol like R_MIPS_JUMP_SLOT
+func mipsGOTRelocations(e *elf.File) []relocation {
+	res := make([]relocation, 0)
+	got, ok_got := dynamicValue(e, elf.DT_PLTGOT)
+	local, ok_local := dynamicValue(e, elf.DT_MIPS_LOCAL_GOTNO)
+	gotsym, ok_gotsym := dynamicValue(e, elf.DT_MIPS_GOTSYM)
+	symtabno, ok_symtabno := dynamicValue(e, elf.DT_MIPS_SYMTABNO)
+	if !ok_got || !ok_local || !ok_gotsym || !ok_symtabno || local > 0x100000 || symtabno > 0x100000 {
+		return res
+	}
+	//the first two entries are reserved for the lazy resolver and the module pointer
+	for i := uint64(2); i < local; i++ {
+		res = append(res, relocation{offset: got + 4*i, typ: uint32(elf.R_MIPS_REL32), implicit: true})
+	}
+	for sym := gotsym; sym < symtabno; sym++ {
+		res = append(res, relocation{offset: got + 4*(local+sym-gotsym), typ: uint32(mipsJumpSlot), symbol: uint32(sym)})
+	}
+	return res
+}
+
+// ApplyRelocations processes the dynamic relocations of e (as mapped by GetSegmentsAt with the same base) in place.
+// It returns the GOT slots that now hold the address of an imported symbol, keyed by slot address
+func ApplyRelocations(e *elf.File, maps map[ds.Range]*ds.MappedRegion, base uint64) map[uint64]string {
+	imports := make(map[uint64]string)
+	if !supportedMachine(e) {
+		return imports
+	}
+	base = LoadBase(e, base)
+	symbols, err := e.DynamicSymbols()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Info("Failed to Parse Dynamic Symbols")
+	}
+	for _, rel := range relocations(e) {
+		addr := base + rel.offset
+		addend := rel.addend
+		if rel.implicit {
+			addend, _ = readPointer(e, maps, addr)
+		}
+		var val uint64
+		switch relocationKind(e, rel) {
+		case relocRelative:
+			val = base + addend
+		case relocImport:
+			target, name, imported := resolveSymbol(e, symbols, rel.symbol, base)
+			if imported {
+				imports[addr] = name
+			}
+			val = target
+		case relocAbsolute:
+			target, _, _ := resolveSymbol(e, symbols, rel.symbol, base)
+			val = target + addend
+		default:
+			log.WithFields(log.Fields{"type": rel.typ, "at": addr}).Debug("Unhandled Relocation")
+			continue
+		}
+		if !writePointer(e, maps, addr, val) {
+			log.WithFields(log.Fields{"at": addr}).Info("Relocation outside of loaded segments")
+		}
+	}
+	return imports
+}
+
+// GetGOTSymbols returns the names of the undefined symbols whose addresses the dynamic linker stores in the GOT, keyed
+// by GOT slot. Unlike the result of ApplyRelocations this includes weak imports.
+func GetGOTSymbols(e *elf.File, base uint64) map[uint64]string {
+	res := make(map[uint64]string)
+	if !supportedMachine(e) {
+		return res
+	}
+	base = LoadBase(e, base)
+	symbols, err := e.DynamicSymbols()
+	if err != nil {
+		return res
+	}
+	for _, rel := range relocations(e) {
+		if relocationKind(e, rel) != relocImport {
+			continue
+		}
+		if rel.symbol == 0 || int(rel.symbol) > len(symbols) || symbols[rel.symbol-1].Section != elf.SHN_UNDEF {
+			continue
+		}
+		res[base+rel.offset] = symbols[rel.symbol-1].Name
+	}
+	return res
+}
+
+// pltJumpTarget decodes the "jmp *disp32(%rip)" a PLT stub starts with, optionally preceded by endbr64 and a bnd
+// prefix, and returns the GOT slot it jumps through
+func pltJumpTarget(stub []byte, addr uint64) (uint64, bool) {
+	start := 0
+	if len(stub) >= 4 && stub[0] == 0xf3 && stub[1] == 0x0f && stub[2] == 0x1e && stub[3] == 0xfa {
+		start += 4
+	}
+	if len(stub) > start && stub[start] == 0xf2 {
+		start += 1
+	}
+	if len(stub) < start+6 || stub[start] != 0xff || stub[start+1] != 0x25 {
+		return 0, false
+	}
+	disp := int32(binary.LittleEndian.Uint32(stub[start+2:]))
+	next_ip := addr + uint64(start+6)
+	return uint64(int64(next_ip) + int64(disp)), true
+}
+
+// aarch64PltJumpTarget decodes the "adrp x16, page; ldr x17, [x16, #offset]" a PLT stub starts with, optionally
+// preceded by bti c, and returns the GOT slot it loads the target from
+func aarch64PltJumpTarget(stub []byte, addr uint64) (uint64, bool) {
+	if len(stub) >= 4 && binary.LittleEndian.Uint32(stub) == 0xd503245f {
+		stub, addr = stub[4:], addr+4
+	}
+	if len(stub) < 8 {
+		return 0, false
+	}
+	adrp, ldr := binary.LittleEndian.Uint32(stub), binary.LittleEndian.Uint32(stub[4:])
+	if adrp&0x9f00001f != 0x90000010 || ldr&0xffc003ff != 0xf9400211 {
+		return 0, false
+	}
+	pages := int64(((adrp>>5)&0x7ffff)<<2|(adrp>>29)&3) << 43 >> 43 //sign extend the 21 bit immediate
+	page := uint64(int64(addr&^0xfff) + pages<<12)
+	return page + uint64((ldr>>10)&0xfff)*8, true
+}
+
+// riscvPltJumpTarget decodes the "auipc t3, %pcrel_hi(slot); ld t3, %pcrel_lo(slot)(t3)" a PLT stub starts with and
+// returns the GOT slot it loads the target from
+func riscvPltJumpTarget(stub []byte, addr uint64) (uint64, bool) {
+	if len(stub) < 8 {
+		return 0, false
+	}
+	auipc, ld := binary.LittleEndian.Uint32(stub), binary.LittleEndian.Uint32(stub[4:])
+	if auipc&0xfff != 0xe17 || ld&0xfffff != 0xe3e03 {
+		return 0, false
+	}
+	hi := int64(int32(auipc & 0xfffff000))
+	lo := int64(int32(ld) >> 20)
+	return uint64(int64(addr) + hi + lo), true
+}
+
+// i386PltJumpTarget decodes the "jmp *slot" a PLT stub of an executable starts with or the "jmp *offset(%ebx)" of a
+// position independent one, where ebx holds the address of the GOT, optionally preceded by endbr32
+func i386PltJumpTarget(stub []byte, got uint64) (uint64, bool) {
+	if len(stub) >= 4 && stub[0] == 0xf3 && stub[1] == 0x0f && stub[2] == 0x1e && stub[3] == 0xfb {
+		stub = stub[4:]
+	}
+	if len(stub) < 6 || stub[0] != 0xff || stub[1] != 0x25 && stub[1] != 0xa3 {
+		return 0, false
+	}
+	disp := binary.LittleEndian.Uint32(stub[2:])
+	if stub[1] == 0x25 {
+		return uint64(disp), true
+	}
+	return uint64(uint32(got) + disp), true
+}
+
+// armPltJumpTarget decodes the "add ip, pc, #hi; add ip, ip, #mid; ldr pc, [ip, #lo]!" a PLT stub starts with,
+// optionally preceded by the "bx pc; nop" entry for Thumb callers, and returns the GOT slot it jumps through
+func armPltJumpTarget(stub []byte, addr uint64) (uint64, bool) {
+	if len(stub) >= 4 && binary.LittleEndian.Uint32(stub) == 0x46c04778 {
+		stub, addr = stub[4:], addr+4
+	}
+	if len(stub) < 12 {
+		return 0, false
+	}
+	add_pc, add_ip, ldr := binary.LittleEndian.Uint32(stub), binary.LittleEndian.Uint32(stub[4:]), binary.LittleEndian.Uint32(stub[8:])
+	if add_pc&0xfffff000 != 0xe28fc000 || add_ip&0xfffff000 != 0xe28cc000 || ldr&0xfffff000 != 0xe5bcf000 {
+		return 0, false
+	}
+	//immediates are 8 bit values rotated right by twice the 4 bit rotation, pc reads as the address + 8
+	rotated := func(ins uint32) uint64 { return uint64(bits.RotateLeft32(ins&0xff, -2*int(ins>>8&0xf))) }
+	return uint64(uint32(addr+8) + uint32(rotated(add_pc)) + uint32(rotated(add_ip)) + ldr&0xfff), true
+}
+
+// mipsPltJumpTarget decodes the "lui $t7, %hi(slot); lw $t9, %lo(slot)($t7)" a PLT stub of a non PIC executable
+// starts with and returns the GOT slot it loads the target from. PIC code calls imports through the GOT directly.
+func mipsPltJumpTarget(stub []byte, order binary.ByteOrder) (uint64, bool) {
+	if len(stub) < 8 {
+		return 0, false
+	}
+	lui, lw := order.Uint32(stub), order.Uint32(stub[4:])
+	if lui&0xffff0000 != 0x3c0f0000 || lw&0xffff0000 != 0x8df90000 {
+		return 0, false
+	}
+	return uint64(lui<<16 + uint32(int32(int16(lw)))), true
+}
+
+// GetPLT maps the addresses of the PLT stubs in .plt, .plt.sec and .plt.got to the name of the imported symbol they
+// jump to. 32 bit PowerPC has no such stubs, its secure PLT is an array of GOT slots and the linker places the call
+// stubs into .text without symbols, calls through them only show up as Imports.
+func GetPLT(e *elf.File, base uint64) map[uint64]string {
+	res := make(map[uint64]string)
+	if !supportedMachine(e) {
+		return res
+	}
+	base = LoadBase(e, base)
+	got := GetGOTSymbols(e, base)
+	stubTarget := pltJumpTarget
+	switch e.Machine {
+	case elf.EM_AARCH64:
+		stubTarget = aarch64PltJumpTarget
+	case elf.EM_RISCV:
+		stubTarget = riscvPltJumpTarget
+	case elf.EM_386:
+		got_pointer, _ := dynamicValue(e, elf.DT_PLTGOT)
+		stubTarget = func(stub []byte, addr uint64) (uint64, bool) { return i386PltJumpTarget(stub, base+got_pointer) }
+	case elf.EM_ARM:
+		stubTarget = armPltJumpTarget
+	case elf.EM_MIPS:
+		stubTarget = func(stub []byte, addr uint64) (uint64, bool) { return mipsPltJumpTarget(stub, e.ByteOrder) }
+	case elf.EM_PPC:
+		return res
+	}
+	for _, name := range []string{".plt", ".plt.sec", ".plt.got"} {
+		sec := e.Section(name)
+		if sec == nil {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "section": name}).Info("Failed to Read PLT")
+			continue
+		}
+		stub_size := sec.Entsize
+		if stub_size == 0 {
+			stub_size = 16
+		}
+		for offset := uint64(0); offset < uint64(len(data)); offset += stub_size {
+			addr := base + sec.Addr + offset
+			slot, ok := stubTarget(data[offset:], addr)
+			if !ok {
+				continue
+			}
+			if import_name, ok := got[slot]; ok {
+				res[addr] = import_name
+			}
+		}
+	}
+	return res
+}
+
+func readPointer(e *elf.File, maps map[ds.Range]*ds.MappedRegion, addr uint64) (uint64, bool) {
+	region := regionFor(maps, addr, pointerSize(e))
+	if region == nil {
+		return 0, false
+	}
+	if pointerSize(e) == 4 {
+		return uint64(e.ByteOrder.Uint32(region.Data[addr-region.Range.From:])), true
+	}
+	return e.ByteOrder.Uint64(region.Data[addr-region.Range.From:]), true
+}
+
+// GetInitFunctions returns the constructors and destructors listed in .preinit_array, .init_array and .fini_array. maps
+// has to be relocated already, since the entries of position independent files are only filled in by relocations
+func GetInitFunctions(e *elf.File, maps map[ds.Range]*ds.MappedRegion, base uint64) []uint64 {
+	res := make([]uint64, 0)
+	base = LoadBase(e, base)
+	for _, sec := range e.Sections {
+		if sec.Type != elf.SHT_PREINIT_ARRAY && sec.Type != elf.SHT_INIT_ARRAY && sec.Type != elf.SHT_FINI_ARRAY {
+			continue
+		}
+		size := pointerSize(e)
+		for addr := base + sec.Addr; addr+size <= base+sec.Addr+sec.Size; addr += size {
+			//0 and -1 are used as terminators by some toolchains
+			if fun, ok := readPointer(e, maps, addr); ok && fun != 0 && fun != ^uint64(0)>>(64-8*size) {
+				res = append(res, fun)
+			}
+		}
+	}
+	return res
+}
+
+// GetExports returns the functions defined in the dynamic symbol table, which survives stripping
+func GetExports(e *elf.File, base uint64) map[ds.Range]*ds.Symbol {
+	res := make(map[ds.Range]*ds.Symbol)
+	base = LoadBase(e, base)
+	symbols, err := e.DynamicSymbols()
+	if err != nil {
+		return res
+	}
+	for _, sym := range symbols {
+		if sym.Section == elf.SHN_UNDEF || elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
+			continue
+		}
+		addr := symbolAddress(e, sym, base)
+		res[ds.NewRange(addr, addr+sym.Size)] = ds.NewSymbol(sym.Name, ds.FUNC)
 	}
 	return res
 }
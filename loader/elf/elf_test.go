@@ -0,0 +1,406 @@
+package elf
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBssIsZeroFilled(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/simple/O0/strings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps := GetSegments(e)
+	data, ok := maps[ds.NewRange(0x600e10, 0x601040)]
+	if !ok {
+		fmt.Printf("missing data segment in %v\n", maps)
+		t.FailNow()
+	}
+	if len(data.Data) != 0x230 {
+		fmt.Printf("data segment has %x bytes, should have Memsz 0x230\n", len(data.Data))
+		t.Fail()
+	}
+	for _, b := range data.Data[0x228:] {
+		if b != 0 {
+			fmt.Printf(".bss not zero filled: %x\n", data.Data[0x228:])
+			t.Fail()
+			break
+		}
+	}
+}
+
+func TestRelocations(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dynamic/pie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := uint64(DefaultLoadBase)
+	maps := GetSegmentsAt(e, base)
+	imports := ApplyRelocations(e, maps, base)
+
+	read := func(addr uint64) uint64 {
+		region := regionFor(maps, base+addr, 8)
+		if region == nil {
+			fmt.Printf("%x not mapped\n", base+addr)
+			t.FailNow()
+		}
+		return e.ByteOrder.Uint64(region.Data[base+addr-region.Range.From:])
+	}
+
+	// operations[] = {add, sub} are R_X86_64_RELATIVE
+	if read(0x4040) != base+0x1189 || read(0x4048) != base+0x118d {
+		fmt.Printf("function pointer table not relocated: %x %x\n", read(0x4040), read(0x4048))
+		t.Fail()
+	}
+	if read(0x4020) != ds.ImportAddress("malloc", 8) || imports[base+0x4020] != "malloc" {
+		fmt.Printf("malloc GOT slot holds %x, imports: %v\n", read(0x4020), imports)
+		t.Fail()
+	}
+	if read(0x3fc8) != 0 {
+		fmt.Printf("weak _ITM_deregisterTMCloneTable resolved to %x\n", read(0x3fc8))
+		t.Fail()
+	}
+	if len(imports) != 7 {
+		fmt.Printf("unexpected imports: %v\n", imports)
+		t.Fail()
+	}
+}
+
+func TestPLT(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dynamic/pie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := uint64(DefaultLoadBase)
+	plt := GetPLT(e, base)
+	expected := map[uint64]string{
+		base + 0x1030: "free",
+		base + 0x1040: "strlen",
+		base + 0x1050: "printf",
+		base + 0x1060: "memcpy",
+		base + 0x1070: "malloc",
+		base + 0x1080: "exit",
+		base + 0x1090: "__cxa_finalize",
+	}
+	if !reflect.DeepEqual(plt, expected) {
+		fmt.Printf("Is: %#v\n", plt)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+}
+
+func TestAArch64PLTStub(t *testing.T) {
+	//adrp x16, +1 page; ldr x17, [x16, #0x18] and adrp x16, -1 page; ldr x17, [x16, #0x18]
+	for stub, slot := range map[string]uint64{"\x10\x00\x00\xb0\x11\x0e\x40\xf9": 0x11018, "\xf0\xff\xff\xf0\x11\x0e\x40\xf9": 0xf018} {
+		if got, ok := aarch64PltJumpTarget([]byte(stub), 0x10400); !ok || got != slot {
+			fmt.Printf("slot of %x is %x, should be %x\n", stub, got, slot)
+			t.Fail()
+		}
+	}
+	//bti c before the stub
+	if got, ok := aarch64PltJumpTarget([]byte("\x5f\x24\x03\xd5\x10\x00\x00\xb0\x11\x0e\x40\xf9"), 0x10ffc); !ok || got != 0x12018 {
+		fmt.Printf("slot behind bti is %x\n", got)
+		t.Fail()
+	}
+}
+
+func TestRISCVPLTStub(t *testing.T) {
+	//auipc t3, 0x2; ld t3, 0x10(t3) and auipc t3, 0x2; ld t3, -8(t3)
+	for stub, slot := range map[string]uint64{"\x17\x2e\x00\x00\x03\x3e\x0e\x01": 0x12410, "\x17\x2e\x00\x00\x03\x3e\x8e\xff": 0x123f8} {
+		if got, ok := riscvPltJumpTarget([]byte(stub), 0x10400); !ok || got != slot {
+			fmt.Printf("slot of %x is %x, should be %x\n", stub, got, slot)
+			t.Fail()
+		}
+	}
+	//the first entry of .plt loads into t2 and is no stub
+	if _, ok := riscvPltJumpTarget([]byte("\x97\x23\x00\x00\x33\x03\xc3\x41"), 0x10400); ok {
+		t.Fail()
+	}
+}
+
+func TestI386Relocations(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/i386/shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := LoadBase(e, DefaultLoadBase)
+	maps := GetSegmentsAt(e, base)
+	imports := ApplyRelocations(e, maps, base)
+	read := func(addr uint64) uint64 {
+		value, ok := readPointer(e, maps, base+addr)
+		if !ok {
+			fmt.Printf("%x not mapped\n", base+addr)
+			t.FailNow()
+		}
+		return value
+	}
+
+	//table[] = {local, imported} are R_386_RELATIVE and R_386_32, the addends are stored in the slots
+	imported := ds.ImportAddress("imported", 4)
+	if read(0x3004) != base+0x1020 || read(0x3008) != imported {
+		fmt.Printf("function pointer table not relocated: %x %x\n", read(0x3004), read(0x3008))
+		t.Fail()
+	}
+	expected := map[uint64]string{base + 0x2ff0: "imported_data", base + 0x3000: "imported"}
+	if read(0x3000) != imported || !reflect.DeepEqual(imports, expected) {
+		fmt.Printf("imported GOT slot holds %x, imports: %v\n", read(0x3000), imports)
+		t.Fail()
+	}
+	if plt := GetPLT(e, base); !reflect.DeepEqual(plt, map[uint64]string{base + 0x1010: "imported"}) {
+		fmt.Printf("unexpected PLT: %v\n", plt)
+		t.Fail()
+	}
+}
+
+func TestARMPLTStub(t *testing.T) {
+	//add ip, pc, #0, 12; add ip, ip, #16, 20; ldr pc, [ip, #0xf44]!
+	stub := "\x00\xc6\x8f\xe2\x10\xca\x8c\xe2\x44\xff\xbc\xe5"
+	if got, ok := armPltJumpTarget([]byte(stub), 0x102b4); !ok || got != 0x21200 {
+		fmt.Printf("slot of %x is %x\n", stub, got)
+		t.Fail()
+	}
+	//bx pc; nop for Thumb callers
+	if got, ok := armPltJumpTarget([]byte("\x78\x47\xc0\x46"+stub), 0x102b0); !ok || got != 0x21200 {
+		fmt.Printf("slot behind the Thumb entry is %x\n", got)
+		t.Fail()
+	}
+}
+
+func TestMIPSPLTStub(t *testing.T) {
+	//lui $t7, 0x41; lw $t9, -0x7ff0($t7)
+	stub := []byte("\x3c\x0f\x00\x41\x8d\xf9\x80\x10")
+	if got, ok := mipsPltJumpTarget(stub, binary.BigEndian); !ok || got != 0x408010 {
+		fmt.Printf("slot of %x is %x\n", stub, got)
+		t.Fail()
+	}
+	if _, ok := mipsPltJumpTarget(stub, binary.LittleEndian); ok {
+		t.Fail()
+	}
+}
+
+func TestDWARFSymbols(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dwarf/inline.debug"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := uint64(DefaultLoadBase)
+	symbols := GetDWARFSymbols(e, base)
+	expected := map[ds.Range]string{
+		ds.NewRange(base+0x1050, base+0x1077): "main",
+		ds.NewRange(base+0x1170, base+0x117a): "sum_of_squares",
+		ds.NewRange(base+0x1180, base+0x1185): "scale",
+	}
+	is := make(map[ds.Range]string)
+	for rng, symbol := range symbols {
+		is[rng] = symbol.Name
+	}
+	if !reflect.DeepEqual(is, expected) {
+		fmt.Printf("Is: %#v\n", is)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+	sum := symbols[ds.NewRange(base+0x1170, base+0x117a)]
+	if sum == nil || filepath.Base(sum.File) != "inline.c" || sum.Line != 7 {
+		fmt.Printf("unexpected declaration %#v\n", sum)
+		t.FailNow()
+	}
+	square := sum.Inlined[ds.NewRange(base+0x1173, base+0x1176)]
+	if len(sum.Inlined) != 2 || square == nil || square.Name != "square" || square.Line != 3 {
+		fmt.Printf("unexpected inlined subroutines %#v\n", sum.Inlined)
+		t.Fail()
+	}
+}
+
+func TestDWARFCrossUnitOrigin(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dwarf/lto"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the inlined helper refers to its abstract origin in the unit of lto_square.c, whose file table lists the header
+	var helper *ds.Symbol
+	for _, symbol := range GetDWARFSymbols(e, 0) {
+		for _, inlined := range symbol.Inlined {
+			if inlined.Name == "helper" {
+				helper = inlined
+			}
+		}
+	}
+	if helper == nil || filepath.Base(helper.File) != "lto_helper.h" || helper.Line != 4 {
+		fmt.Printf("unexpected declaration %#v\n", helper)
+		t.Fail()
+	}
+}
+
+func TestFDEs(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dwarf/inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := make([]ds.Range, 0)
+	for _, fde := range GetFDEs(e, 0) {
+		is = append(is, fde.Range)
+	}
+	expected := []ds.Range{
+		ds.NewRange(0x1080, 0x10a2),
+		ds.NewRange(0x1020, 0x1040),
+		ds.NewRange(0x1040, 0x1048),
+		ds.NewRange(0x1170, 0x117a),
+		ds.NewRange(0x1180, 0x1185),
+		ds.NewRange(0x1050, 0x1077),
+	}
+	if !reflect.DeepEqual(is, expected) {
+		fmt.Printf("Is: %#v\n", is)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+}
+
+func TestInitFunctions(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dwarf/inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := uint64(DefaultLoadBase)
+	maps := GetSegmentsAt(e, base)
+	ApplyRelocations(e, maps, base)
+	is := GetInitFunctions(e, maps, base)
+	expected := []uint64{base + 0x1160, base + 0x1120}
+	if !reflect.DeepEqual(is, expected) {
+		fmt.Printf("Is: %x\n", is)
+		fmt.Printf("Sh: %x\n", expected)
+		t.Fail()
+	}
+}
+
+func TestCFARows(t *testing.T) {
+	e, err := elf.NewFile(ioReader("../../samples/dwarf/inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[uint64][]CFARow)
+	for _, fde := range GetFDEs(e, 0) {
+		rows[fde.Range.From], err = fde.CFARows()
+		if err != nil {
+			fmt.Printf("%v: %v\n", fde.Range, err)
+			t.Fail()
+		}
+	}
+	expected := map[uint64][]CFARow{
+		0x1050: {{0x1050, CFARule{Register: 7, Offset: 8}}, {0x1054, CFARule{Register: 7, Offset: 16}}, {0x1076, CFARule{Register: 7, Offset: 8}}},
+		0x1020: {{0x1020, CFARule{Register: 7, Offset: 16}}, {0x1026, CFARule{Register: 7, Offset: 24}}, {0x1030, CFARule{Expression: true}}},
+	}
+	for addr, exp := range expected {
+		if !reflect.DeepEqual(rows[addr], exp) {
+			fmt.Printf("Is: %#v\n", rows[addr])
+			fmt.Printf("Sh: %#v\n", exp)
+			t.Fail()
+		}
+	}
+}
+
+func TestEHFrameWithoutSections(t *testing.T) {
+	with, err := elf.NewFile(ioReader("../../samples/dwarf/inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	without, err := elf.NewFile(ioReader("../../samples/dwarf/inline_nosections"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := uint64(DefaultLoadBase)
+	expected := GetFDEs(with, base)
+	is := GetFDEs(without, base)
+	if len(is) != len(expected) || len(is) == 0 {
+		fmt.Printf("found %d FDEs without section headers, %d with\n", len(is), len(expected))
+		t.FailNow()
+	}
+	for i := range is {
+		if is[i].Range != expected[i].Range {
+			fmt.Printf("Is: %v Sh: %v\n", is[i].Range, expected[i].Range)
+			t.Fail()
+		}
+	}
+}
+
+func TestDWARFOriginCycle(t *testing.T) {
+	//two subprograms that are each other's abstract origin, only the second one has a name
+	abbrev := []byte{1, 0x2e, 0, 0x31, 0x13, 0, 0, 2, 0x2e, 0, 0x31, 0x13, 0x03, 0x08, 0, 0, 0}
+	info := []byte{0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 8, 1, 16, 0, 0, 0, 2, 11, 0, 0, 0, 'f', 0, 0}
+	binary.LittleEndian.PutUint32(info, uint32(len(info)-4))
+	d, err := dwarf.New(abbrev, nil, nil, info, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := d.Reader().Next()
+	if err != nil || entry == nil {
+		t.Fatal(err)
+	}
+	if res := lookupOrigin(d, entry, readUnits(d), make(map[dwarf.Offset]origin)); res.name != "f" {
+		fmt.Printf("Is: %#v\n", res)
+		t.Fail()
+	}
+}
+
+func TestInvalidSegments(t *testing.T) {
+	data, err := ioutil.ReadFile("../../samples/simple/O0/strings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the file size of the writable segment exceeds its memory size, the memory size of the executable one is absurd
+	skipped := make([]ds.Range, 0)
+	phoff := binary.LittleEndian.Uint64(data[32:])
+	for i, prog := range e.Progs {
+		hdr := data[phoff+uint64(i)*56:]
+		switch {
+		case prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_W != 0:
+			binary.LittleEndian.PutUint64(hdr[32:], prog.Memsz+1)
+			skipped = append(skipped, ds.NewRange(prog.Vaddr, prog.Vaddr+prog.Memsz))
+		case prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0:
+			binary.LittleEndian.PutUint64(hdr[40:], 1<<40)
+			skipped = append(skipped, ds.NewRange(prog.Vaddr, prog.Vaddr+1<<40))
+		}
+	}
+	e, err = elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps := GetSegments(e)
+	if len(skipped) != 2 || len(maps) == 0 {
+		fmt.Printf("patched %d segments, %d left\n", len(skipped), len(maps))
+		t.Fail()
+	}
+	for _, rng := range skipped {
+		if _, ok := maps[rng]; ok {
+			fmt.Printf("invalid segment %v loaded\n", rng)
+			t.Fail()
+		}
+	}
+}
+
+func TestTruncatedCIE(t *testing.T) {
+	//version 1, "zR", code align 1, data align -8, return register 16, then an augmentation length beyond the entry and
+	//one that overflows int
+	for _, length := range [][]byte{{0x40}, {0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}} {
+		body := append([]byte{0, 0, 0, 0, 1, 'z', 'R', 0, 1, 0x78, 16}, length...)
+		body = append(body, 0x1b, 0, 0)
+		data := make([]byte, 4, 4+len(body))
+		binary.LittleEndian.PutUint32(data, uint32(len(body)))
+		data = append(data, body...)
+		if _, err := ParseEHFrame(data, 0x1000, binary.LittleEndian, 8); err == nil {
+			fmt.Printf("no error for augmentation length %x\n", length)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"github.com/ranmrdrakono/indika/arch"
+	elfloader "github.com/ranmrdrakono/indika/loader/elf"
+)
+
+// Frame describes the stack frame of a function by the call frame information of its FDE, it is meant to be used as
+// blanket_emulator.FrameInfo
+type Frame struct {
+	rows []elfloader.CFARow
+	arch arch.Arch
+}
+
+// FrameInfo returns the stack frame of the function containing addr, or nil if there is no unwind information for it
+func (s *Binary) FrameInfo(addr uint64) *Frame {
+	for _, fde := range s.FDEs {
+		if fde.Range.From <= addr && addr < fde.Range.To {
+			rows, err := fde.CFARows()
+			if err != nil || len(rows) == 0 {
+				return nil
+			}
+			return &Frame{rows: rows, arch: s.ArchAt(fde.Range.From)}
+		}
+	}
+	return nil
+}
+
+func (f *Frame) ruleAt(addr uint64) (elfloader.CFARule, bool) {
+	if addr < f.rows[0].Addr {
+		return elfloader.CFARule{}, false
+	}
+	res := f.rows[0].Rule
+	for _, row := range f.rows {
+		if row.Addr > addr {
+			break
+		}
+		res = row.Rule
+	}
+	return res, true
+}
+
+// CFA returns register and offset that yield the canonical frame address at addr
+func (f *Frame) CFA(addr uint64) (int, int64, bool) {
+	rule, ok := f.ruleAt(addr)
+	if !ok || rule.Expression {
+		return 0, 0, false
+	}
+	reg, ok := f.arch.GetDwarfRegister(rule.Register)
+	return reg, rule.Offset, ok
+}
+
+// Size is the largest distance between the stack pointer and the canonical frame address. Once the frame is
+// addressed by another register (a frame pointer) further stack allocations are not described anymore, the size is
+// the one reached before the switch. Frames that start out without the stack pointer or use expressions have no size.
+func (f *Frame) Size() (uint64, bool) {
+	size := int64(0)
+	for i, row := range f.rows {
+		if row.Rule.Expression {
+			return 0, false
+		}
+		reg, ok := f.arch.GetDwarfRegister(row.Rule.Register)
+		if !ok || reg != f.arch.GetRegStack() {
+			if i == 0 {
+				return 0, false
+			}
+			break
+		}
+		if row.Rule.Offset > size {
+			size = row.Rule.Offset
+		}
+	}
+	return uint64(size), true
+}
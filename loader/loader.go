@@ -0,0 +1,390 @@
+package loader
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"github.com/ranmrdrakono/indika/disassemble"
+	elfloader "github.com/ranmrdrakono/indika/loader/elf"
+	macholoader "github.com/ranmrdrakono/indika/loader/macho"
+	peloader "github.com/ranmrdrakono/indika/loader/pe"
+	"io/ioutil"
+)
+
+type Format uint
+
+const (
+	RAW   Format = 0
+	ELF   Format = 1
+	PE    Format = 2
+	MACHO Format = 3
+)
+
+func (f Format) String() string {
+	switch f {
+	case ELF:
+		return "ELF"
+	case PE:
+		return "PE"
+	case MACHO:
+		return "Mach-O"
+	}
+	return "raw"
+}
+
+// Binary is the format independent view on a loaded executable
+type Binary struct {
+	Format   Format
+	Arch     arch.Arch
+	Base     uint64
+	Entry    uint64
+	Segments map[ds.Range]*ds.MappedRegion
+	Symbols  map[ds.Range]*ds.Symbol
+	Imports  map[uint64]string //GOT slot -> name of the imported symbol stored in it
+	PLT      map[uint64]string //PLT stub -> name of the imported symbol it jumps to
+	FDEs     []*elfloader.FDE  //unwind information of ELF files
+	Thumb    map[uint64]bool   //functions of ARM binaries that are Thumb code, see ArchAt
+	noReturn map[uint64]bool   //see NoReturnFunctions
+}
+
+func DetectFormat(data []byte) Format {
+	if len(data) < 4 {
+		return RAW
+	}
+	if bytes.Equal(data[:4], []byte(elf.ELFMAG)) {
+		return ELF
+	}
+	if data[0] == 'M' && data[1] == 'Z' {
+		return PE
+	}
+	switch binary.BigEndian.Uint32(data) {
+	case macho.Magic32, macho.Magic64, macho.MagicFat:
+		return MACHO
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case macho.Magic32, macho.Magic64:
+		return MACHO
+	}
+	return RAW
+}
+
+// Open detects the format of the file at path by its magic. Files without a known magic are mapped as raw x86-64 code
+// at address 0, position independent ELF files at elf.DefaultLoadBase (elf.DefaultLoadBase32 for 32 bit files)
+func Open(path string) (*Binary, error) {
+	return OpenAt(path, elfloader.DefaultLoadBase)
+}
+
+// OpenAt works like Open, but maps position independent ELF files at base
+func OpenAt(path string, base uint64) (*Binary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch DetectFormat(data) {
+	case ELF:
+		return newELFBinary(data, base)
+	case PE:
+		return newPEBinary(data)
+	case MACHO:
+		return newMachOBinary(data)
+	}
+	return NewRawBinary(data, 0, &arch.ArchX86_64{}), nil
+}
+
+func NewRawBinary(data []byte, base uint64, architecture arch.Arch) *Binary {
+	rng := ds.NewRange(base, base+uint64(len(data)))
+	return &Binary{
+		Format:   RAW,
+		Arch:     architecture,
+		Base:     base,
+		Entry:    base,
+		Segments: map[ds.Range]*ds.MappedRegion{rng: ds.NewMappedRegion(data, ds.R|ds.X, rng)},
+		Symbols:  make(map[ds.Range]*ds.Symbol),
+		Imports:  make(map[uint64]string),
+		PLT:      make(map[uint64]string),
+		Thumb:    make(map[uint64]bool),
+	}
+}
+
+func newELFBinary(data []byte, base uint64) (*Binary, error) {
+	e, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var architecture arch.Arch
+	switch e.Machine {
+	case elf.EM_X86_64:
+		architecture = &arch.ArchX86_64{}
+	case elf.EM_386:
+		architecture = &arch.ArchX86{}
+	case elf.EM_AARCH64:
+		architecture = &arch.ArchAArch64{}
+	case elf.EM_ARM:
+		architecture = &arch.ArchARM{Thumb: e.Entry&1 != 0}
+	case elf.EM_MIPS:
+		if e.Class != elf.ELFCLASS32 {
+			return nil, fmt.Errorf("unsupported ELF machine %v (64 bit)", e.Machine)
+		}
+		architecture = &arch.ArchMIPS{LittleEndian: e.Data == elf.ELFDATA2LSB}
+	case elf.EM_PPC:
+		architecture = &arch.ArchPPC{}
+	case elf.EM_RISCV:
+		if e.Class != elf.ELFCLASS64 {
+			return nil, fmt.Errorf("unsupported ELF machine %v (32 bit)", e.Machine)
+		}
+		architecture = &arch.ArchRISCV64{}
+	default:
+		return nil, fmt.Errorf("unsupported ELF machine %v", e.Machine)
+	}
+	base = elfloader.LoadBase(e, base)
+	segments := elfloader.GetSegmentsAt(e, base)
+	symbols := elfloader.GetSymbolsAt(e, base)
+	mergeSymbols(symbols, elfloader.GetDWARFSymbols(e, base))
+	bin := &Binary{
+		Format:   ELF,
+		Arch:     architecture,
+		Base:     base,
+		Entry:    elfloader.GetEntryAt(e, base),
+		Segments: segments,
+		Symbols:  symbols,
+		Imports:  elfloader.ApplyRelocations(e, segments, base),
+		PLT:      elfloader.GetPLT(e, base),
+		FDEs:     elfloader.GetFDEs(e, base),
+		Thumb:    elfloader.GetThumbFunctions(e, base),
+	}
+	if !hasFunctions(symbols) { //stripped
+		known := make(map[ds.Range]*ds.Symbol)
+		for _, fde := range bin.FDEs {
+			if bin.coversPLT(fde.Range) {
+				continue
+			}
+			known[fde.Range] = ds.NewSymbol(fmt.Sprintf("sub_%x", fde.Range.From), ds.FUNC)
+		}
+		mergeSymbols(known, elfloader.GetExports(e, base))
+		mergeSymbols(symbols, bin.DiscoverFunctions(elfloader.GetInitFunctions(e, segments, base), known))
+	}
+	return bin, nil
+}
+
+func (s *Binary) coversPLT(rng ds.Range) bool {
+	for stub := range s.PLT {
+		if rng.From <= stub && stub < rng.To {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSymbols adds other to symbols. A symbol of other replaces the one with exactly the same range, partially
+// overlapping ranges are both kept. Callers merge the more reliable source last: DWARF wins over the symbol table, and
+// in stripped files exports win over the sub_<addr> names of FDE ranges, which DiscoverFunctions keeps in turn.
+func mergeSymbols(symbols map[ds.Range]*ds.Symbol, other map[ds.Range]*ds.Symbol) {
+	for rng, symbol := range other {
+		symbols[rng] = symbol
+	}
+}
+
+// LoadDebugInfo adds the functions described by the DWARF information in a separate debug file (as produced by
+// objcopy --only-keep-debug) to the symbols of an ELF binary
+func (s *Binary) LoadDebugInfo(path string) error {
+	if s.Format != ELF {
+		return fmt.Errorf("debug files are only supported for ELF, not %v", s.Format)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	e, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	mergeSymbols(s.Symbols, elfloader.GetDWARFSymbols(e, s.Base))
+	return nil
+}
+
+func newPEBinary(data []byte) (*Binary, error) {
+	p, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var architecture arch.Arch
+	switch p.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		architecture = &arch.ArchX86_64{Win64: true}
+	case pe.IMAGE_FILE_MACHINE_I386:
+		architecture = &arch.ArchX86{}
+	default:
+		return nil, fmt.Errorf("unsupported PE machine 0x%x", p.Machine)
+	}
+	return &Binary{
+		Format:   PE,
+		Arch:     architecture,
+		Base:     peloader.ImageBase(p),
+		Entry:    peloader.GetEntry(p),
+		Segments: peloader.GetSegments(p),
+		Symbols:  peloader.GetSymbols(p),
+		Imports:  make(map[uint64]string),
+		PLT:      make(map[uint64]string),
+	}, nil
+}
+
+func newMachOBinary(data []byte) (*Binary, error) {
+	m, err := macholoader.NewFile(bytes.NewReader(data), macho.CpuAmd64)
+	if err != nil {
+		return nil, err
+	}
+	var architecture arch.Arch
+	switch m.Cpu {
+	case macho.CpuAmd64:
+		architecture = &arch.ArchX86_64{}
+	default:
+		return nil, fmt.Errorf("unsupported Mach-O cpu %v", m.Cpu)
+	}
+	return &Binary{
+		Format:   MACHO,
+		Arch:     architecture,
+		Entry:    macholoader.GetEntry(m),
+		Segments: macholoader.GetSegments(m),
+		Symbols:  macholoader.GetSymbols(m),
+		Imports:  make(map[uint64]string),
+		PLT:      make(map[uint64]string),
+	}, nil
+}
+
+// FindMapping returns a loaded region intersecting needle
+func (s *Binary) FindMapping(needle ds.Range) *ds.MappedRegion {
+	for rng, mapping := range s.Segments {
+		if mapping.Loaded && rng.IntersectsRange(needle) {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// RegionAt returns the loaded region containing addr
+func (s *Binary) RegionAt(addr uint64) *ds.MappedRegion {
+	for rng, mapping := range s.Segments {
+		if mapping.Loaded && rng.From <= addr && addr < rng.To {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// ArchAt returns the architecture the function at addr is disassembled and emulated with. ARM binaries mix ARM and
+// Thumb functions, all others have a single Arch.
+func (s *Binary) ArchAt(addr uint64) arch.Arch {
+	if _, ok := s.Arch.(*arch.ArchARM); ok {
+		return &arch.ArchARM{Thumb: s.Thumb[addr]}
+	}
+	return s.Arch
+}
+
+// functionStart strips the Thumb bit from the address of an ARM function and remembers the function as Thumb code
+func (s *Binary) functionStart(addr uint64) uint64 {
+	if _, ok := s.Arch.(*arch.ArchARM); ok && addr&1 != 0 {
+		addr &^= 1
+		s.Thumb[addr] = true
+	}
+	return addr
+}
+
+// readOnlyRegions are the loaded regions whose content can not change at runtime, e.g. those holding jump tables
+func (s *Binary) readOnlyRegions() []*ds.MappedRegion {
+	res := make([]*ds.MappedRegion, 0)
+	for _, mapping := range s.Segments {
+		if mapping.Loaded && mapping.Flags&ds.W == 0 {
+			res = append(res, mapping)
+		}
+	}
+	return res
+}
+
+// ImportName identifies a call target as library function, either by its PLT stub or by the fake address the GOT slot
+// of the import was relocated to
+func (s *Binary) ImportName(target uint64) (string, bool) {
+	if name, ok := s.PLT[target]; ok {
+		return name, true
+	}
+	if ds.IsImportAddress(target, s.Arch.GetPointerSize()) {
+		for _, name := range s.Imports {
+			if s.importAddress(name) == target {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ImportTargets maps the fake address of every import to its name, this is where calls into libraries end up
+func (s *Binary) ImportTargets() map[uint64]string {
+	res := make(map[uint64]string)
+	for _, name := range s.Imports {
+		res[s.importAddress(name)] = name
+	}
+	for _, name := range s.PLT {
+		res[s.importAddress(name)] = name
+	}
+	return res
+}
+
+// importAddress is the fake address the loaders relocate the import name to, it depends on the pointer size
+func (s *Binary) importAddress(name string) uint64 {
+	return ds.ImportAddress(name, s.Arch.GetPointerSize())
+}
+
+// GlobalPointer returns the address of __global_pointer$, which the RISC-V runtime loads into gp, if the symbol survived
+// stripping
+func (s *Binary) GlobalPointer() (uint64, bool) {
+	for rng, symbol := range s.Symbols {
+		if symbol.Name == "__global_pointer$" {
+			return rng.From, true
+		}
+	}
+	return 0, false
+}
+
+func filterEmptyBBs(bbs map[uint64]ds.BB) map[uint64]ds.BB {
+	res := make(map[uint64]ds.BB)
+	for addr, bb := range bbs {
+		if !bb.Rng.IsEmpty() {
+			res[addr] = bb
+		}
+	}
+	return res
+}
+
+// ExtractCFG recovers the control flow graph of the function in rng, returns nil if rng is not backed by the image.
+// Calls of NoReturnFunctions do not fall through.
+func (s *Binary) ExtractCFG(rng ds.Range) *ds.CFG {
+	return s.extractCFG(rng, disassemble.Context{Data: s.readOnlyRegions(), NoReturn: s.NoReturnFunctions()})
+}
+
+// extractCFG disassembles the function in rng with its ArchAt, the Arch of ctx is ignored
+func (s *Binary) extractCFG(rng ds.Range, ctx disassemble.Context) *ds.CFG {
+	ctx.Arch = s.ArchAt(rng.From)
+	maped := s.FindMapping(rng)
+	if maped == nil {
+		return nil
+	}
+	if rng.From < maped.Range.From || rng.To > maped.Range.From+uint64(len(maped.Data)) {
+		log.WithFields(log.Fields{"function range": rng, "mapping": maped.Range}).Info("Function not covered by file content")
+		return nil
+	}
+	return disassemble.GetCFG(maped.Range.From, maped.Data, rng, &ctx)
+}
+
+// ExtractBBs disassembles the basic blocks of the function in rng, returns nil if rng is not backed by the image
+func (s *Binary) ExtractBBs(rng ds.Range) map[uint64]ds.BB {
+	cfg := s.ExtractCFG(rng)
+	if cfg == nil {
+		return nil
+	}
+	return filterEmptyBBs(cfg.Blocks)
+}
@@ -0,0 +1,275 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io"
+	"os"
+	"sort"
+)
+
+func check(e error) {
+	if e != nil {
+		log.WithFields(log.Fields{"error": e}).Fatal("unexpected error")
+		panic(e)
+	}
+}
+
+func ioReader(file string) io.ReaderAt {
+	r, err := os.Open(file)
+	check(err)
+	return r
+}
+
+const (
+	LC_UNIXTHREAD      = 0x5
+	LC_FUNCTION_STARTS = 0x26
+	LC_MAIN            = 0x80000028
+
+	x86_THREAD_STATE64 = 4
+
+	VM_PROT_READ    = 0x1
+	VM_PROT_WRITE   = 0x2
+	VM_PROT_EXECUTE = 0x4
+
+	N_STAB = 0xe0
+	N_TYPE = 0x0e
+	N_SECT = 0x0e
+
+	S_ATTR_PURE_INSTRUCTIONS = 0x80000000
+	S_ATTR_SOME_INSTRUCTIONS = 0x00000400
+)
+
+// NewFile parses a thin Mach-O or, for universal binaries, the slice built for cpu
+func NewFile(r io.ReaderAt, cpu macho.Cpu) (*macho.File, error) {
+	fat, err := macho.NewFatFile(r)
+	if err == macho.ErrNotFat {
+		return macho.NewFile(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return SelectSlice(fat, cpu)
+}
+
+func SelectSlice(fat *macho.FatFile, cpu macho.Cpu) (*macho.File, error) {
+	available := make([]macho.Cpu, 0, len(fat.Arches))
+	for _, arch := range fat.Arches {
+		if arch.Cpu == cpu {
+			return arch.File, nil
+		}
+		available = append(available, arch.Cpu)
+	}
+	return nil, fmt.Errorf("universal binary has no %v slice (has %v)", cpu, available)
+}
+
+func machoFlagsToPageFlags(prot uint32) ds.PageFlags {
+	res := ds.PageFlags(0)
+	if prot&VM_PROT_EXECUTE != 0 {
+		res |= ds.X
+	}
+	if prot&VM_PROT_READ != 0 {
+		res |= ds.R
+	}
+	if prot&VM_PROT_WRITE != 0 {
+		res |= ds.W
+	}
+	return res
+}
+
+func segments(m *macho.File) []*macho.Segment {
+	res := make([]*macho.Segment, 0)
+	for _, load := range m.Loads {
+		if seg, ok := load.(*macho.Segment); ok {
+			res = append(res, seg)
+		}
+	}
+	return res
+}
+
+func GetSegments(m *macho.File) map[ds.Range]*ds.MappedRegion {
+	res := make(map[ds.Range]*ds.MappedRegion)
+	for _, seg := range segments(m) {
+		// __PAGEZERO reserves the low 4GB without any access rights
+		if seg.Memsz == 0 || seg.Prot == 0 {
+			continue
+		}
+		info := new(ds.MappedRegion)
+		info.Range = ds.NewRange(seg.Addr, seg.Addr+seg.Memsz)
+		info.Flags = machoFlagsToPageFlags(seg.Prot)
+		info.Loaded = true
+		data, err := seg.Data()
+		check(err)
+		// zerofill sections live behind the file content of the segment
+		info.Data = make([]byte, seg.Memsz)
+		copy(info.Data, data)
+		res[info.Range] = info
+	}
+	return res
+}
+
+func textSegment(m *macho.File) *macho.Segment {
+	for _, seg := range segments(m) {
+		if seg.Offset == 0 && seg.Filesz != 0 {
+			return seg
+		}
+	}
+	return nil
+}
+
+// GetFunctionStarts decodes LC_FUNCTION_STARTS, a ULEB128 encoded list of deltas between consecutive function starts,
+// beginning at the __TEXT segment. Unlike the symbol table it survives stripping.
+func GetFunctionStarts(m *macho.File) []uint64 {
+	res := make([]uint64, 0)
+	linkedit := m.Segment("__LINKEDIT")
+	text := textSegment(m)
+	if linkedit == nil || text == nil {
+		return res
+	}
+	for _, load := range m.Loads {
+		raw, ok := load.(macho.LoadBytes)
+		if !ok || len(raw) < 16 || m.ByteOrder.Uint32(raw) != LC_FUNCTION_STARTS {
+			continue
+		}
+		offset := uint64(m.ByteOrder.Uint32(raw[8:]))
+		size := uint64(m.ByteOrder.Uint32(raw[12:]))
+		if offset < linkedit.Offset || offset-linkedit.Offset+size > linkedit.Filesz {
+			log.WithFields(log.Fields{"offset": offset, "size": size}).Info("Function Starts outside of __LINKEDIT")
+			return res
+		}
+		data := make([]byte, size)
+		if _, err := linkedit.ReadAt(data, int64(offset-linkedit.Offset)); err != nil {
+			log.WithFields(log.Fields{"error": err}).Info("Failed to Read Function Starts")
+			return res
+		}
+		addr := text.Addr
+		for len(data) > 0 {
+			delta, n := binary.Uvarint(data)
+			if n <= 0 || delta == 0 {
+				break
+			}
+			data = data[n:]
+			addr += delta
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// GetEntry returns the entry point from LC_MAIN or, for old binaries, the rip of the initial LC_UNIXTHREAD state
+func GetEntry(m *macho.File) uint64 {
+	for _, load := range m.Loads {
+		raw, ok := load.(macho.LoadBytes)
+		if !ok || len(raw) < 16 {
+			continue
+		}
+		switch m.ByteOrder.Uint32(raw) {
+		case LC_MAIN:
+			if text := textSegment(m); text != nil {
+				return text.Addr + m.ByteOrder.Uint64(raw[8:])
+			}
+		case LC_UNIXTHREAD:
+			// rip follows rax..r15 in the x86_64 thread state
+			if m.ByteOrder.Uint32(raw[8:]) == x86_THREAD_STATE64 && len(raw) >= 16+17*8 {
+				return m.ByteOrder.Uint64(raw[16+16*8:])
+			}
+		}
+	}
+	return 0
+}
+
+func sectionForAddr(m *macho.File, addr uint64) *macho.Section {
+	for _, sec := range m.Sections {
+		if sec.Addr <= addr && addr < sec.Addr+sec.Size {
+			return sec
+		}
+	}
+	return nil
+}
+
+func isCode(sec *macho.Section) bool {
+	return sec.Flags&(S_ATTR_PURE_INSTRUCTIONS|S_ATTR_SOME_INSTRUCTIONS) != 0
+}
+
+func machoSymbolType(m *macho.File, sym macho.Symbol) ds.SymbolType {
+	if sym.Sect == 0 || int(sym.Sect) > len(m.Sections) {
+		return ds.UNKNOWN
+	}
+	if isCode(m.Sections[sym.Sect-1]) {
+		return ds.FUNC
+	}
+	return ds.DATA
+}
+
+type UIntArray []uint64
+
+func (s UIntArray) Len() int           { return len(s) }
+func (s UIntArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s UIntArray) Less(i, j int) bool { return s[i] < s[j] }
+
+// symbolEnd guesses the end of a symbol, Mach-O does not record sizes: the next known start in the same section or the
+// end of the section
+func symbolEnd(m *macho.File, starts []uint64, addr uint64) uint64 {
+	sec := sectionForAddr(m, addr)
+	if sec == nil {
+		return addr
+	}
+	end := sec.Addr + sec.Size
+	idx := sort.Search(len(starts), func(i int) bool { return starts[i] > addr })
+	if idx < len(starts) && starts[idx] < end {
+		return starts[idx]
+	}
+	return end
+}
+
+// GetSymbols returns the defined symbols from the symbol table. Functions only known from LC_FUNCTION_STARTS are
+// reported as sub_<addr>.
+func GetSymbols(m *macho.File) map[ds.Range]*ds.Symbol {
+	res := make(map[ds.Range]*ds.Symbol)
+	function_starts := GetFunctionStarts(m)
+
+	defined := make(map[uint64]macho.Symbol)
+	if m.Symtab != nil {
+		for _, sym := range m.Symtab.Syms {
+			if sym.Type&N_STAB != 0 || sym.Type&N_TYPE != N_SECT {
+				continue
+			}
+			defined[sym.Value] = sym
+		}
+	}
+
+	starts := make([]uint64, 0, len(defined)+len(function_starts))
+	for addr, _ := range defined {
+		starts = append(starts, addr)
+	}
+	for _, addr := range function_starts {
+		if _, ok := defined[addr]; !ok {
+			starts = append(starts, addr)
+		}
+	}
+	sort.Sort(UIntArray(starts))
+
+	for addr, sym := range defined {
+		res[ds.NewRange(addr, symbolEnd(m, starts, addr))] = ds.NewSymbol(sym.Name, machoSymbolType(m, sym))
+	}
+	for _, addr := range function_starts {
+		if _, ok := defined[addr]; ok {
+			continue
+		}
+		name := fmt.Sprintf("sub_%x", addr)
+		res[ds.NewRange(addr, symbolEnd(m, starts, addr))] = ds.NewSymbol(name, ds.FUNC)
+	}
+	return res
+}
+
+func Run(file string) {
+	f := ioReader(file)
+	_macho, err := NewFile(f, macho.CpuAmd64)
+	check(err)
+	maps := GetSegments(_macho)
+	_ = GetSymbols(_macho)
+	fmt.Printf("%v\n", maps)
+}
@@ -0,0 +1,100 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func load(t *testing.T, filename string) *macho.File {
+	f, err := NewFile(ioReader(filename), macho.CpuAmd64)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", filename, err)
+	}
+	return f
+}
+
+func TestSegments(t *testing.T) {
+	maps := GetSegments(load(t, "../../samples/macho/tiny"))
+
+	expected := map[ds.Range]ds.PageFlags{
+		ds.NewRange(0x100000000, 0x100001000): ds.R | ds.X,
+		ds.NewRange(0x100001000, 0x100003000): ds.R | ds.W,
+		ds.NewRange(0x100003000, 0x100004000): ds.R,
+	}
+	is := make(map[ds.Range]ds.PageFlags)
+	for rng, region := range maps {
+		is[rng] = region.Flags
+	}
+	if !reflect.DeepEqual(is, expected) {
+		fmt.Printf("Is: %#v\n", is)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+	text := maps[ds.NewRange(0x100000000, 0x100001000)]
+	if len(text.Data) != 0x1000 || text.Data[0x800] != 0x48 {
+		fmt.Printf("unexpected __TEXT content\n")
+		t.Fail()
+	}
+	data := maps[ds.NewRange(0x100001000, 0x100003000)]
+	if len(data.Data) != 0x2000 || data.Data[0] != 0x88 || data.Data[0x1fff] != 0 {
+		fmt.Printf("unexpected __DATA content\n")
+		t.Fail()
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	expected := map[ds.Range]*ds.Symbol{
+		ds.NewRange(0x100000800, 0x100000805): ds.NewSymbol("_add_one", ds.FUNC),
+		ds.NewRange(0x100000805, 0x10000080f): ds.NewSymbol("sub_100000805", ds.FUNC),
+		ds.NewRange(0x10000080f, 0x10000081a): ds.NewSymbol("_start", ds.FUNC),
+		ds.NewRange(0x100001000, 0x100001008): ds.NewSymbol("_counter", ds.DATA),
+	}
+	for _, filename := range []string{"../../samples/macho/tiny", "../../samples/macho/tiny_fat"} {
+		symbols := GetSymbols(load(t, filename))
+		if !reflect.DeepEqual(symbols, expected) {
+			fmt.Printf("%s\nIs: %#v\n", filename, symbols)
+			fmt.Printf("Sh: %#v\n", expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestMissingSlice(t *testing.T) {
+	if _, err := NewFile(ioReader("../../samples/macho/tiny_fat"), macho.CpuPpc); err == nil {
+		t.Fail()
+	}
+}
+
+func TestFunctionStartsSize(t *testing.T) {
+	data, err := ioutil.ReadFile("../../samples/macho/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the datasize of LC_FUNCTION_STARTS claims almost 4GB, far beyond the end of __LINKEDIT
+	pos := uint32(32)
+	for i := uint32(0); i < binary.LittleEndian.Uint32(data[16:]); i++ {
+		if binary.LittleEndian.Uint32(data[pos:]) == LC_FUNCTION_STARTS {
+			binary.LittleEndian.PutUint32(data[pos+12:], 0xfffffff0)
+		}
+		pos += binary.LittleEndian.Uint32(data[pos+4:])
+	}
+	m, err := NewFile(bytes.NewReader(data), macho.CpuAmd64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	starts := GetFunctionStarts(m)
+	runtime.ReadMemStats(&after)
+	if len(starts) != 0 || after.TotalAlloc-before.TotalAlloc > 1<<20 {
+		fmt.Printf("%d starts, %d bytes allocated\n", len(starts), after.TotalAlloc-before.TotalAlloc)
+		t.Fail()
+	}
+}
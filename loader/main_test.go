@@ -1,12 +1,441 @@
-package blanket_emulator
+package loader
 
 import (
-	//	"fmt"
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ranmrdrakono/indika/arch"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
-	//  "gopkg.in/vmihailenco/msgpack.v2"
-	"github.com/ranmrdrakono/indika/loader/elf"
 )
 
-func TestRun(t *testing.T) {
-	elf.Run("../samples/binutils/bin_O1/gdb")
+func TestOpen(t *testing.T) {
+	expected := []struct {
+		filename string
+		format   Format
+		entry    uint64
+	}{
+		{"../samples/simple/O0/strings", ELF, 0x400400},
+		{"../samples/dynamic/pie", ELF, 0x555555554000 + 0x10a0},
+		{"../samples/pe/tiny.dll", PE, 0x1000100f},
+		{"../samples/macho/tiny_fat", MACHO, 0x10000080f},
+		{"../samples/simple/one_bb", RAW, 0},
+	}
+	for _, exp := range expected {
+		bin, err := Open(exp.filename)
+		if err != nil {
+			fmt.Printf("%s: %v\n", exp.filename, err)
+			t.Fail()
+			continue
+		}
+		if bin.Format != exp.format || bin.Entry != exp.entry || bin.Arch == nil {
+			fmt.Printf("%s: is %v@%x, should be %v@%x\n", exp.filename, bin.Format, bin.Entry, exp.format, exp.entry)
+			t.Fail()
+		}
+		if bin.RegionAt(bin.Entry) == nil {
+			fmt.Printf("%s: entry not mapped\n", exp.filename)
+			t.Fail()
+		}
+		//only PE binaries use the Windows calling convention
+		if a, ok := bin.Arch.(*arch.ArchX86_64); !ok || a.Win64 != (exp.format == PE) {
+			fmt.Printf("%s: unexpected arch %#v\n", exp.filename, bin.Arch)
+			t.Fail()
+		}
+	}
+}
+
+func TestExtractBBs(t *testing.T) {
+	bin, err := Open("../samples/pe/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bbs := bin.ExtractBBs(ds.NewRange(0x10001005, 0x1000100f))
+	if len(bbs) != 2 {
+		fmt.Printf("unexpected blocks %#v\n", bbs)
+		t.Fail()
+	}
+	if bbs := bin.ExtractBBs(ds.NewRange(0x20000000, 0x20000010)); bbs != nil {
+		fmt.Printf("unmapped range disassembled to %#v\n", bbs)
+		t.Fail()
+	}
+}
+
+func TestImportName(t *testing.T) {
+	bin, err := Open("../samples/dynamic/pie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []uint64{bin.Base + 0x1070, ds.ImportAddress("malloc", 8)} {
+		if name, ok := bin.ImportName(target); !ok || name != "malloc" {
+			fmt.Printf("%x resolved to %q\n", target, name)
+			t.Fail()
+		}
+	}
+	if targets := bin.ImportTargets(); targets[ds.ImportAddress("printf", 8)] != "printf" {
+		fmt.Printf("unexpected import targets %#v\n", targets)
+		t.Fail()
+	}
+	if name, ok := bin.ImportName(bin.Base + 0x1189); ok {
+		fmt.Printf("local function resolved to import %q\n", name)
+		t.Fail()
+	}
+}
+
+func TestLoadDebugInfo(t *testing.T) {
+	bin, err := Open("../samples/dwarf/inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := ds.NewRange(bin.Base+0x1170, bin.Base+0x117a)
+	if symbol := bin.Symbols[rng]; symbol == nil || symbol.Name != fmt.Sprintf("sub_%x", rng.From) {
+		fmt.Printf("stripped binary has symbol %#v\n", symbol)
+		t.Fail()
+	}
+	if err := bin.LoadDebugInfo("../samples/dwarf/inline.debug"); err != nil {
+		t.Fatal(err)
+	}
+	if symbol := bin.Symbols[rng]; symbol == nil || symbol.Name != "sum_of_squares" || symbol.Type != ds.FUNC {
+		fmt.Printf("debug info not loaded: %#v\n", symbol)
+		t.Fail()
+	}
+}
+
+func TestDiscoverFunctions(t *testing.T) {
+	bin, err := Open("../samples/dwarf/inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ds.Range{
+		ds.NewRange(0x1050, 0x1077), //main, from .eh_frame
+		ds.NewRange(0x1080, 0x10a2), //_start, entry point
+		ds.NewRange(0x1120, 0x1160), //__do_global_dtors_aux, from .fini_array
+		ds.NewRange(0x1160, 0x1170), //frame_dummy, from .init_array
+		ds.NewRange(0x1170, 0x117a), //sum_of_squares
+		ds.NewRange(0x1180, 0x1185), //scale
+	}
+	for _, rng := range expected {
+		rng = ds.NewRange(bin.Base+rng.From, bin.Base+rng.To)
+		symbol := bin.Symbols[rng]
+		if symbol == nil || symbol.Type != ds.FUNC || symbol.Name != fmt.Sprintf("sub_%x", rng.From) {
+			fmt.Printf("%v: %#v\n", rng, symbol)
+			t.Fail()
+		}
+	}
+	for rng, symbol := range bin.Symbols {
+		if _, ok := bin.PLT[rng.From]; ok || rng.From == bin.Base+0x1020 {
+			fmt.Printf("PLT discovered as function %#v\n", symbol)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameInfo(t *testing.T) {
+	bin, err := Open("../samples/dwarf/inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := bin.Base + 0x1050
+	frame := bin.FrameInfo(main)
+	if frame == nil {
+		t.Fatal("no frame for main")
+	}
+	stack := bin.Arch.GetRegStack()
+	for addr, offset := range map[uint64]int64{main: 8, main + 0x10: 16, main + 0x26: 8} {
+		if reg, off, ok := frame.CFA(addr); !ok || reg != stack || off != offset {
+			fmt.Printf("CFA at %x is %d+%d\n", addr, reg, off)
+			t.Fail()
+		}
+	}
+	if size, ok := frame.Size(); !ok || size != 16 {
+		fmt.Printf("frame size %d %v\n", size, ok)
+		t.Fail()
+	}
+	if bin.FrameInfo(bin.Base+0x1030) == nil {
+		fmt.Printf("no frame for the PLT\n")
+		t.Fail()
+	} else if _, ok := bin.FrameInfo(bin.Base + 0x1030).Size(); ok {
+		fmt.Printf("PLT frame described by an expression has a size\n")
+		t.Fail()
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	bin, err := Open("../samples/dynamic/pie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := bin.CallGraph()
+	main, duplicate, start := bin.Base+0x11d9, bin.Base+0x11ae, bin.Base+0x10a0
+	malloc, libc_start := ds.ImportAddress("malloc", 8), ds.ImportAddress("__libc_start_main", 8)
+	if node := graph.Nodes[malloc]; node == nil || !node.Import || node.Name != "malloc" {
+		fmt.Printf("unexpected import node %#v\n", node)
+		t.Fail()
+	}
+	if sites := graph.Calls[main][duplicate]; !reflect.DeepEqual(sites, []uint64{bin.Base + 0x11e4}) {
+		fmt.Printf("unexpected call sites of duplicate %#v\n", sites)
+		t.Fail()
+	}
+	if sites := graph.Calls[duplicate][malloc]; !reflect.DeepEqual(sites, []uint64{bin.Base + 0x11c1}) {
+		fmt.Printf("unexpected call sites of malloc %#v\n", sites)
+		t.Fail()
+	}
+	if sites := graph.Calls[start][libc_start]; !reflect.DeepEqual(sites, []uint64{bin.Base + 0x10bb}) {
+		fmt.Printf("call through the GOT not resolved %#v\n", graph.Calls[start])
+		t.Fail()
+	}
+	if callers := graph.Callers(duplicate); !reflect.DeepEqual(callers, []uint64{main}) {
+		fmt.Printf("unexpected callers %#v\n", callers)
+		t.Fail()
+	}
+
+	var dot bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	edge := fmt.Sprintf("\"0x%x\" -> \"0x%x\" [label=\"0x%x\"];", main, duplicate, bin.Base+0x11e4)
+	if !strings.Contains(dot.String(), edge) {
+		fmt.Printf("missing %s in\n%s", edge, dot.String())
+		t.Fail()
+	}
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Nodes []ds.CallGraphNode
+		Edges []ds.CallGraphEdge
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Nodes) != len(graph.Nodes) || len(decoded.Edges) != len(graph.Edges()) {
+		fmt.Printf("unexpected json %s\n", data)
+		t.Fail()
+	}
+}
+
+func TestNoReturnFunctions(t *testing.T) {
+	bin, err := Open("../samples/dynamic/noreturn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, trap, checked_div := bin.Base+0x1159, bin.Base+0x116c, bin.Base+0x116e
+	noreturn := bin.NoReturnFunctions()
+	if !noreturn[fail] || !noreturn[trap] || !noreturn[ds.ImportAddress("exit", 8)] || noreturn[checked_div] {
+		fmt.Printf("unexpected noreturn functions %#v\n", noreturn)
+		t.Fail()
+	}
+	cfg := bin.ExtractCFG(ds.NewRange(checked_div, bin.Base+0x1188))
+	expected := *ds.NewBBWithEdges(bin.Base+0x1178, bin.Base+0x1188, ds.Edge{Kind: ds.CALL, Target: fail})
+	if cfg == nil || !reflect.DeepEqual(cfg.Blocks[bin.Base+0x1178], expected) || !cfg.Returns {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+}
+
+func TestAArch64(t *testing.T) {
+	bin, err := Open("../samples/aarch64/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bin.Arch.(*arch.ArchAArch64); !ok || bin.Entry != 0x400078 {
+		fmt.Printf("unexpected arch %#v or entry %x\n", bin.Arch, bin.Entry)
+		t.Fail()
+	}
+	start, f := ds.NewRange(0x400078, 0x400080), ds.NewRange(0x400080, 0x40008c)
+	if bin.Symbols[start] == nil || bin.Symbols[f] == nil {
+		fmt.Printf("unexpected symbols %#v\n", bin.Symbols)
+		t.FailNow()
+	}
+	if cfg := bin.ExtractCFG(start); cfg == nil || len(cfg.Blocks) != 2 || cfg.Returns {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+	if bbs := bin.ExtractBBs(f); len(bbs) != 3 {
+		fmt.Printf("unexpected blocks %#v\n", bbs)
+		t.Fail()
+	}
+}
+
+func TestARMInterworking(t *testing.T) {
+	bin, err := Open("../samples/arm/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bin.Arch.(*arch.ArchARM); !ok || bin.Entry != 0x10054 {
+		fmt.Printf("unexpected arch %#v or entry %x\n", bin.Arch, bin.Entry)
+		t.Fail()
+	}
+	//the Thumb function is only known from the blx of the ARM entry
+	start, f := ds.NewRange(0x10054, 0x1005c), ds.NewRange(0x1005c, 0x10066)
+	if bin.Symbols[start] == nil || bin.Symbols[f] == nil {
+		fmt.Printf("unexpected symbols %#v\n", bin.Symbols)
+		t.FailNow()
+	}
+	if bin.ArchAt(start.From).ToCapstoneModeDescription() == bin.ArchAt(f.From).ToCapstoneModeDescription() || !bin.Thumb[f.From] {
+		fmt.Printf("%x is not Thumb code: %v\n", f.From, bin.Thumb)
+		t.Fail()
+	}
+	if cfg := bin.ExtractCFG(start); cfg == nil || len(cfg.Blocks) != 2 || cfg.Returns {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+	//bxeq lr in the IT block falls through
+	if bbs := bin.ExtractBBs(f); len(bbs) != 2 {
+		fmt.Printf("unexpected blocks %#v\n", bbs)
+		t.Fail()
+	}
+}
+
+func TestI386(t *testing.T) {
+	bin, err := Open("../samples/i386/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//position independent 32 bit files can not be mapped at elf.DefaultLoadBase
+	base := uint64(0x56555000)
+	if _, ok := bin.Arch.(*arch.ArchX86); !ok || bin.Base != base || bin.Entry != base+0x100a {
+		fmt.Printf("unexpected arch %#v, base %x or entry %x\n", bin.Arch, bin.Base, bin.Entry)
+		t.Fail()
+	}
+	f := ds.NewRange(base+0x1000, base+0x100a)
+	if bin.Symbols[f] == nil || bin.Symbols[f].Name != "f" {
+		fmt.Printf("unexpected symbols %#v\n", bin.Symbols)
+		t.FailNow()
+	}
+	//ret 4
+	if cfg := bin.ExtractCFG(f); cfg == nil || len(cfg.Blocks) != 1 || !cfg.Returns {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+
+	//the GOT of 32 bit files is described by SHT_REL relocations
+	lib, err := Open("../samples/i386/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.PLT[base+0x1010] != "imported" || lib.Imports[base+0x3000] != "imported" || lib.Imports[base+0x2ff0] != "imported_data" {
+		fmt.Printf("unexpected PLT %v or imports %v\n", lib.PLT, lib.Imports)
+		t.Fail()
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	mips, err := Open("../samples/mips/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := mips.Arch.(*arch.ArchMIPS); !ok || a.LittleEndian {
+		fmt.Printf("unexpected arch %#v\n", mips.Arch)
+		t.Fail()
+	}
+	//the binary is stripped, f is only known from its big endian .eh_frame
+	f := ds.NewRange(0x400060, 0x400074)
+	if mips.Symbols[f] == nil || len(mips.FDEs) != 1 {
+		fmt.Printf("unexpected symbols %#v\n", mips.Symbols)
+		t.FailNow()
+	}
+	//beqz and jr with their delay slots and the addiu in between
+	if bbs := mips.ExtractBBs(f); len(bbs) != 3 || bbs[0x400060].Rng.To != 0x400068 || bbs[0x40006c].Rng.To != 0x400074 {
+		fmt.Printf("unexpected blocks %#v\n", bbs)
+		t.Fail()
+	}
+
+	ppc, err := Open("../samples/ppc/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ppc.Arch.(*arch.ArchPPC); !ok || ppc.Entry != 0x10000054 {
+		fmt.Printf("unexpected arch %#v or entry %x\n", ppc.Arch, ppc.Entry)
+		t.Fail()
+	}
+	f = ds.NewRange(0x1000005c, 0x1000006c)
+	if ppc.Symbols[f] == nil {
+		fmt.Printf("unexpected symbols %#v\n", ppc.Symbols)
+		t.FailNow()
+	}
+	if cfg := ppc.ExtractCFG(f); cfg == nil || len(cfg.Blocks) != 2 || !cfg.Returns {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+}
+
+func TestRISCV64(t *testing.T) {
+	bin, err := Open("../samples/riscv64/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bin.Arch.(*arch.ArchRISCV64); !ok || bin.Entry != 0x10078 {
+		fmt.Printf("unexpected arch %#v or entry %x\n", bin.Arch, bin.Entry)
+		t.Fail()
+	}
+	//f is only known as the target of the auipc, jalr pair in _start
+	f := ds.NewRange(0x10082, 0x10088)
+	if bin.Symbols[f] == nil {
+		fmt.Printf("unexpected symbols %#v\n", bin.Symbols)
+		t.FailNow()
+	}
+	if cfg := bin.ExtractCFG(f); cfg == nil || len(cfg.Blocks) != 3 || !cfg.Returns || cfg.Blocks[0x10084].Rng.To != 0x10086 {
+		fmt.Printf("unexpected cfg %#v\n", cfg)
+		t.Fail()
+	}
+}
+
+func TestGlobalPointer(t *testing.T) {
+	bin := NewRawBinary(make([]byte, 0x10), 0x10000, &arch.ArchRISCV64{})
+	if gp, ok := bin.GlobalPointer(); ok {
+		fmt.Printf("global pointer %x without symbol\n", gp)
+		t.Fail()
+	}
+	bin.Symbols[ds.NewRange(0x10800, 0x10800)] = ds.NewSymbol("__global_pointer$", ds.UNKNOWN)
+	if gp, ok := bin.GlobalPointer(); !ok || gp != 0x10800 {
+		fmt.Printf("global pointer %x %v\n", gp, ok)
+		t.Fail()
+	}
+}
+
+func TestUnsupportedMachine(t *testing.T) {
+	data, err := ioutil.ReadFile("../samples/macho/tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(macho.CpuArm64))
+	if bin, err := newMachOBinary(data); err == nil {
+		fmt.Printf("arm64 Mach-O loaded as %#v\n", bin.Arch)
+		t.Fail()
+	}
+
+	//ELF header of a big endian MIPS64 executable without segments
+	header := append([]byte("\x7fELF\x02\x02\x01"), make([]byte, 57)...)
+	binary.BigEndian.PutUint16(header[16:], uint16(elf.ET_EXEC))
+	binary.BigEndian.PutUint16(header[18:], uint16(elf.EM_MIPS))
+	binary.BigEndian.PutUint32(header[20:], uint32(elf.EV_CURRENT))
+	binary.BigEndian.PutUint16(header[52:], 64)
+	if bin, err := newELFBinary(header, 0); err == nil {
+		fmt.Printf("MIPS64 loaded as %#v\n", bin.Arch)
+		t.Fail()
+	}
+}
+
+func TestFramePointerSize(t *testing.T) {
+	bin, err := Open("../samples/simple/O0/strings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//str_reverse: push rbp; mov rbp, rsp; sub rsp, 0x18, the CFA moves to rbp after the push
+	frame := bin.FrameInfo(0x400517)
+	if frame == nil {
+		t.Fatal("no frame for str_reverse")
+	}
+	if size, ok := frame.Size(); !ok || size != 16 {
+		fmt.Printf("frame size %d %v\n", size, ok)
+		t.Fail()
+	}
+	if reg, off, ok := frame.CFA(0x40051b); !ok || reg != bin.Arch.GetRegStackBase() || off != 16 {
+		fmt.Printf("CFA behind mov rbp, rsp is %d+%d\n", reg, off)
+		t.Fail()
+	}
 }
@@ -0,0 +1,63 @@
+package loader
+
+import (
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"github.com/ranmrdrakono/indika/disassemble"
+)
+
+// noReturnImports marks the PLT stubs, GOT slots and import addresses of library functions that never return
+func (s *Binary) noReturnImports() map[uint64]bool {
+	res := make(map[uint64]bool)
+	for stub, name := range s.PLT {
+		if ds.NoReturnImports[name] {
+			res[stub] = true
+			res[s.importAddress(name)] = true
+		}
+	}
+	for slot, name := range s.Imports {
+		if ds.NoReturnImports[name] {
+			res[slot] = true
+			res[s.importAddress(name)] = true
+		}
+	}
+	return res
+}
+
+// NoReturnFunctions returns the call targets that never return: noreturn imports and the functions in Symbols of which
+// every path ends in a call of another noreturn function, hlt or ud2. It is computed once: every function is
+// disassembled while building the call graph, afterwards only the callers of newly found noreturn functions are
+// disassembled again, since a function can only be found to not return after its callees were.
+func (s *Binary) NoReturnFunctions() map[uint64]bool {
+	if s.noReturn != nil {
+		return s.noReturn
+	}
+	res := s.noReturnImports()
+	ctx := disassemble.Context{Data: s.readOnlyRegions(), NoReturn: res}
+	functions := make(map[uint64][]ds.Range)
+	worklist := make([]uint64, 0)
+	check := func(rng ds.Range) *ds.CFG {
+		cfg := s.extractCFG(rng, ctx)
+		if cfg != nil && len(cfg.Blocks) > 0 && !cfg.Returns && !res[rng.From] {
+			res[rng.From] = true
+			worklist = append(worklist, rng.From)
+		}
+		return cfg
+	}
+	graph := s.callGraph(func(rng ds.Range) *ds.CFG {
+		functions[rng.From] = append(functions[rng.From], rng)
+		return check(rng)
+	})
+	for len(worklist) > 0 {
+		callee := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		for _, caller := range graph.Callers(callee) {
+			for _, rng := range functions[caller] {
+				if !res[caller] {
+					check(rng)
+				}
+			}
+		}
+	}
+	s.noReturn = res
+	return res
+}
@@ -0,0 +1,341 @@
+package pe
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io"
+	"os"
+	"sort"
+)
+
+func check(e error) {
+	if e != nil {
+		log.WithFields(log.Fields{"error": e}).Fatal("unexpected error")
+		panic(e)
+	}
+}
+
+func ioReader(file string) io.ReaderAt {
+	r, err := os.Open(file)
+	check(err)
+	return r
+}
+
+const (
+	IMAGE_SYM_CLASS_EXTERNAL = 2
+	IMAGE_SYM_CLASS_STATIC   = 3
+	IMAGE_SYM_CLASS_FILE     = 103
+	IMAGE_SYM_CLASS_SECTION  = 104
+
+	IMAGE_SYM_DTYPE_FUNCTION = 2
+)
+
+// ImageBase returns the preferred load address of the image, all section and symbol addresses are relative to it
+func ImageBase(p *pe.File) uint64 {
+	switch hdr := p.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		return uint64(hdr.ImageBase)
+	case *pe.OptionalHeader64:
+		return hdr.ImageBase
+	}
+	return 0
+}
+
+func GetEntry(p *pe.File) uint64 {
+	switch hdr := p.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		return ImageBase(p) + uint64(hdr.AddressOfEntryPoint)
+	case *pe.OptionalHeader64:
+		return ImageBase(p) + uint64(hdr.AddressOfEntryPoint)
+	}
+	return 0
+}
+
+func sectionAlignment(p *pe.File) uint64 {
+	switch hdr := p.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		return uint64(hdr.SectionAlignment)
+	case *pe.OptionalHeader64:
+		return uint64(hdr.SectionAlignment)
+	}
+	return 0x1000
+}
+
+func dataDirectory(p *pe.File, index int) pe.DataDirectory {
+	switch hdr := p.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if uint32(index) < hdr.NumberOfRvaAndSizes {
+			return hdr.DataDirectory[index]
+		}
+	case *pe.OptionalHeader64:
+		if uint32(index) < hdr.NumberOfRvaAndSizes {
+			return hdr.DataDirectory[index]
+		}
+	}
+	return pe.DataDirectory{}
+}
+
+func alignUp(val, alignment uint64) uint64 {
+	if alignment == 0 {
+		return val
+	}
+	return (val + alignment - 1) &^ (alignment - 1)
+}
+
+func virtualSize(sec *pe.Section) uint64 {
+	if sec.VirtualSize == 0 {
+		return uint64(sec.Size)
+	}
+	return uint64(sec.VirtualSize)
+}
+
+func peFlagsToPageFlags(in uint32) ds.PageFlags {
+	res := ds.PageFlags(0)
+	if in&pe.IMAGE_SCN_MEM_EXECUTE != 0 {
+		res |= ds.X
+	}
+	if in&pe.IMAGE_SCN_MEM_READ != 0 {
+		res |= ds.R
+	}
+	if in&pe.IMAGE_SCN_MEM_WRITE != 0 {
+		res |= ds.W
+	}
+	return res
+}
+
+func GetSegments(p *pe.File) map[ds.Range]*ds.MappedRegion {
+	res := make(map[ds.Range]*ds.MappedRegion)
+	base := ImageBase(p)
+	alignment := sectionAlignment(p)
+	for _, sec := range p.Sections {
+		vsize := virtualSize(sec)
+		if vsize == 0 {
+			continue
+		}
+		from := base + uint64(sec.VirtualAddress)
+		info := new(ds.MappedRegion)
+		info.Range = ds.NewRange(from, from+alignUp(vsize, alignment))
+		info.Flags = peFlagsToPageFlags(sec.Characteristics)
+		info.Loaded = true
+		raw, err := sec.Data()
+		check(err)
+		// the raw data is padded to the file alignment and may be larger than the mapped size, the remainder of the
+		// mapped size (.bss) is zero initialized
+		info.Data = make([]byte, vsize)
+		copy(info.Data, raw)
+		res[info.Range] = info
+	}
+	return res
+}
+
+func sectionForRVA(p *pe.File, rva uint32) *pe.Section {
+	for _, sec := range p.Sections {
+		if sec.VirtualAddress <= rva && uint64(rva) < uint64(sec.VirtualAddress)+virtualSize(sec) {
+			return sec
+		}
+	}
+	return nil
+}
+
+// readRVA returns size bytes at rva, nil unless they are all inside one section. size is 64 bit so that sizes computed
+// from counts in the file can't wrap.
+func readRVA(p *pe.File, rva uint32, size uint64) []byte {
+	sec := sectionForRVA(p, rva)
+	if sec == nil {
+		return nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil
+	}
+	offset := uint64(rva - sec.VirtualAddress)
+	if offset+size > uint64(len(data)) {
+		return nil
+	}
+	return data[offset : offset+size]
+}
+
+func readCString(p *pe.File, rva uint32) string {
+	sec := sectionForRVA(p, rva)
+	if sec == nil {
+		return ""
+	}
+	data, err := sec.Data()
+	if err != nil || rva-sec.VirtualAddress >= uint32(len(data)) {
+		return ""
+	}
+	data = data[rva-sec.VirtualAddress:]
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
+
+func isExecutableRVA(p *pe.File, rva uint32) bool {
+	sec := sectionForRVA(p, rva)
+	return sec != nil && sec.Characteristics&pe.IMAGE_SCN_MEM_EXECUTE != 0
+}
+
+type peSymbol struct {
+	rva  uint32
+	name string
+	typ  ds.SymbolType
+}
+
+// getExports walks the export directory, forwarded exports point into the export directory itself and are skipped.
+// The counts come from the file: tables that don't fit into their section are rejected and every entry is bounds
+// checked, so that malformed files are loaded without their exports instead of crashing the loader.
+func getExports(p *pe.File) []peSymbol {
+	res := make([]peSymbol, 0)
+	dir := dataDirectory(p, pe.IMAGE_DIRECTORY_ENTRY_EXPORT)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return res
+	}
+	hdr := readRVA(p, dir.VirtualAddress, 40)
+	if hdr == nil {
+		log.WithFields(log.Fields{"rva": dir.VirtualAddress}).Info("Failed to Parse Export Directory")
+		return res
+	}
+	le := binary.LittleEndian
+	num_functions := uint64(le.Uint32(hdr[20:]))
+	num_names := uint64(le.Uint32(hdr[24:]))
+	functions := readRVA(p, le.Uint32(hdr[28:]), 4*num_functions)
+	names := readRVA(p, le.Uint32(hdr[32:]), 4*num_names)
+	ordinals := readRVA(p, le.Uint32(hdr[36:]), 2*num_names)
+	if functions == nil || names == nil || ordinals == nil {
+		log.WithFields(log.Fields{"rva": dir.VirtualAddress}).Info("Failed to Parse Export Tables")
+		return res
+	}
+	for i := uint64(0); i < num_names; i++ {
+		if 2*i+2 > uint64(len(ordinals)) || 4*i+4 > uint64(len(names)) {
+			break
+		}
+		ordinal := uint64(le.Uint16(ordinals[2*i:]))
+		if ordinal >= num_functions || 4*ordinal+4 > uint64(len(functions)) {
+			continue
+		}
+		rva := le.Uint32(functions[4*ordinal:])
+		if rva >= dir.VirtualAddress && rva < dir.VirtualAddress+dir.Size {
+			continue
+		}
+		typ := ds.DATA
+		if isExecutableRVA(p, rva) {
+			typ = ds.FUNC
+		}
+		res = append(res, peSymbol{rva: rva, name: readCString(p, le.Uint32(names[4*i:])), typ: typ})
+	}
+	return res
+}
+
+func coffSymbolType(p *pe.File, sym *pe.Symbol) ds.SymbolType {
+	sec := p.Sections[sym.SectionNumber-1]
+	switch {
+	case sym.StorageClass == IMAGE_SYM_CLASS_FILE:
+		return ds.FILE
+	case sym.StorageClass == IMAGE_SYM_CLASS_SECTION:
+		return ds.SECTION
+	case sym.StorageClass == IMAGE_SYM_CLASS_STATIC && sym.Value == 0 && sym.Name == sec.Name:
+		return ds.SECTION
+	case (sym.Type>>4)&3 == IMAGE_SYM_DTYPE_FUNCTION:
+		return ds.FUNC
+	case sec.Characteristics&pe.IMAGE_SCN_MEM_EXECUTE != 0:
+		return ds.UNKNOWN
+	}
+	return ds.DATA
+}
+
+func getCOFFSymbols(p *pe.File) []peSymbol {
+	res := make([]peSymbol, 0)
+	for _, sym := range p.Symbols {
+		// absolute, debug and undefined symbols have no section
+		if sym.SectionNumber <= 0 || int(sym.SectionNumber) > len(p.Sections) {
+			continue
+		}
+		rva := p.Sections[sym.SectionNumber-1].VirtualAddress + sym.Value
+		res = append(res, peSymbol{rva: rva, name: sym.Name, typ: coffSymbolType(p, sym)})
+	}
+	return res
+}
+
+// getFunctionTable returns the begin/end pairs from the x64 exception directory (.pdata). These are the only reliable
+// function sizes a PE carries without debug information
+func getFunctionTable(p *pe.File) map[uint32]uint32 {
+	res := make(map[uint32]uint32)
+	if p.Machine != pe.IMAGE_FILE_MACHINE_AMD64 {
+		return res
+	}
+	dir := dataDirectory(p, pe.IMAGE_DIRECTORY_ENTRY_EXCEPTION)
+	table := readRVA(p, dir.VirtualAddress, uint64(dir.Size))
+	if dir.VirtualAddress == 0 || table == nil {
+		return res
+	}
+	for i := 0; i+12 <= len(table); i += 12 {
+		begin := binary.LittleEndian.Uint32(table[i:])
+		end := binary.LittleEndian.Uint32(table[i+4:])
+		if begin < end {
+			res[begin] = end
+		}
+	}
+	return res
+}
+
+type UInt32Array []uint32
+
+func (s UInt32Array) Len() int           { return len(s) }
+func (s UInt32Array) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s UInt32Array) Less(i, j int) bool { return s[i] < s[j] }
+
+// symbolEnd guesses the end of a symbol without size information: the next symbol start in the same section or the end
+// of the section
+func symbolEnd(p *pe.File, starts []uint32, rva uint32) uint32 {
+	sec := sectionForRVA(p, rva)
+	if sec == nil {
+		return rva
+	}
+	end := sec.VirtualAddress + uint32(virtualSize(sec))
+	idx := sort.Search(len(starts), func(i int) bool { return starts[i] > rva })
+	if idx < len(starts) && starts[idx] < end {
+		return starts[idx]
+	}
+	return end
+}
+
+func GetSymbols(p *pe.File) map[ds.Range]*ds.Symbol {
+	res := make(map[ds.Range]*ds.Symbol)
+	base := ImageBase(p)
+	// exports come last so that they take precedence over linker generated labels at the same address
+	symbols := append(getCOFFSymbols(p), getExports(p)...)
+	function_table := getFunctionTable(p)
+
+	starts := make([]uint32, 0, len(symbols)+len(function_table))
+	for _, sym := range symbols {
+		starts = append(starts, sym.rva)
+	}
+	for begin, _ := range function_table {
+		starts = append(starts, begin)
+	}
+	sort.Sort(UInt32Array(starts))
+
+	for _, sym := range symbols {
+		end, ok := function_table[sym.rva]
+		if !ok {
+			end = symbolEnd(p, starts, sym.rva)
+		}
+		res[ds.NewRange(base+uint64(sym.rva), base+uint64(end))] = ds.NewSymbol(sym.name, sym.typ)
+	}
+	return res
+}
+
+func Run(file string) {
+	f := ioReader(file)
+	_pe, err := pe.NewFile(f)
+	check(err)
+	maps := GetSegments(_pe)
+	_ = GetSymbols(_pe)
+	fmt.Printf("%v\n", maps)
+}
@@ -0,0 +1,119 @@
+package pe
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	ds "github.com/ranmrdrakono/indika/data_structures"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func load(t *testing.T, filename string) *pe.File {
+	f, err := pe.Open(filename)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", filename, err)
+	}
+	return f
+}
+
+func TestSegments(t *testing.T) {
+	maps := GetSegments(load(t, "../../samples/pe/tiny.dll"))
+
+	expected := map[ds.Range]ds.PageFlags{
+		ds.NewRange(0x10001000, 0x10002000): ds.R | ds.X,
+		ds.NewRange(0x10002000, 0x10003000): ds.R | ds.W,
+		ds.NewRange(0x10003000, 0x10004000): ds.R,
+		ds.NewRange(0x10004000, 0x10005000): ds.R,
+		ds.NewRange(0x10005000, 0x10007000): ds.R | ds.W,
+		ds.NewRange(0x10007000, 0x10008000): ds.R,
+		ds.NewRange(0x10008000, 0x10009000): ds.R | ds.W,
+	}
+	is := make(map[ds.Range]ds.PageFlags)
+	for rng, region := range maps {
+		is[rng] = region.Flags
+	}
+	if !reflect.DeepEqual(is, expected) {
+		fmt.Printf("Is: %#v\n", is)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+
+	text := maps[ds.NewRange(0x10001000, 0x10002000)]
+	if len(text.Data) != 0x40 || text.Data[0] != 0x48 || text.Data[4] != 0xc3 {
+		fmt.Printf("unexpected .text content: %x\n", text.Data)
+		t.Fail()
+	}
+	bss := maps[ds.NewRange(0x10005000, 0x10007000)]
+	if len(bss.Data) != 0x2000 || bss.Data[0] != 0 || bss.Data[0x1fff] != 0 {
+		fmt.Printf(".bss should be zero filled, has %d bytes\n", len(bss.Data))
+		t.Fail()
+	}
+}
+
+func TestExports(t *testing.T) {
+	symbols := GetSymbols(load(t, "../../samples/pe/tiny_stripped.dll"))
+
+	expected := map[ds.Range]*ds.Symbol{
+		ds.NewRange(0x10001000, 0x10001005): ds.NewSymbol("add_one", ds.FUNC),
+		ds.NewRange(0x10001005, 0x1000100f): ds.NewSymbol("add_two", ds.FUNC),
+		ds.NewRange(0x1000100f, 0x1000101a): ds.NewSymbol("start", ds.FUNC),
+		ds.NewRange(0x10002000, 0x10002008): ds.NewSymbol("counter", ds.DATA),
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		fmt.Printf("Is: %#v\n", symbols)
+		fmt.Printf("Sh: %#v\n", expected)
+		t.Fail()
+	}
+}
+
+func TestCOFFSymbols(t *testing.T) {
+	symbols := GetSymbols(load(t, "../../samples/pe/tiny.dll"))
+
+	expected := map[ds.Range]*ds.Symbol{
+		ds.NewRange(0x10001000, 0x10001005): ds.NewSymbol("add_one", ds.FUNC),
+		ds.NewRange(0x1000100f, 0x1000101a): ds.NewSymbol("start", ds.FUNC),
+	}
+	for rng, sym := range expected {
+		if !reflect.DeepEqual(symbols[rng], sym) {
+			fmt.Printf("Is: %#v\n", symbols[rng])
+			fmt.Printf("Sh: %#v\n", sym)
+			t.Fail()
+		}
+	}
+	// buffer shares its address with the linker generated __bss_start__
+	if bss, ok := symbols[ds.NewRange(0x10005000, 0x10007000)]; !ok || bss.Type != ds.DATA {
+		fmt.Printf("missing .bss symbol: %#v\n", bss)
+		t.Fail()
+	}
+}
+
+func TestTruncatedExports(t *testing.T) {
+	filename := "../../samples/pe/tiny_stripped.dll"
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := load(t, filename)
+	dir := dataDirectory(p, pe.IMAGE_DIRECTORY_ENTRY_EXPORT)
+	sec := sectionForRVA(p, dir.VirtualAddress)
+	counts := sec.Offset + dir.VirtualAddress - sec.VirtualAddress + 20
+
+	//4*num_functions wraps to 4 in 32 bit, as do 4*num_names and 2*num_names, the name tables are larger than their
+	//section
+	for _, patch := range [][2]uint32{{0x40000001, 4}, {4, 0x80000001}, {4, 0x10000}} {
+		patched := append([]byte{}, data...)
+		binary.LittleEndian.PutUint32(patched[counts:], patch[0])
+		binary.LittleEndian.PutUint32(patched[counts+4:], patch[1])
+		f, err := pe.NewFile(bytes.NewReader(patched))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exports := getExports(f); len(exports) != 0 {
+			fmt.Printf("exports of a truncated table %#v: %#v\n", patch, exports)
+			t.Fail()
+		}
+	}
+}
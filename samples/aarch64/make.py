@@ -0,0 +1,22 @@
+# builds a minimal AArch64 ELF executable by hand, there is no cross toolchain on our build hosts
+import struct
+
+BASE = 0x400000
+CODE = struct.pack("<5I",
+    0x94000002,  # _start: bl f
+    0xd4200000,  #         brk #0
+    0xb4000040,  # f:      cbz x0, 1f
+    0x91000400,  #         add x0, x0, #1
+    0xd65f03c0,  # 1:      ret
+)
+
+EHDR_SIZE, PHDR_SIZE = 64, 56
+entry = BASE + EHDR_SIZE + PHDR_SIZE
+size = EHDR_SIZE + PHDR_SIZE + len(CODE)
+
+ident = b"\x7fELF" + bytes([2, 1, 1, 0]) + bytes(8)
+ehdr = ident + struct.pack("<HHIQQQIHHHHHH", 2, 183, 1, entry, EHDR_SIZE, 0, 0, EHDR_SIZE, PHDR_SIZE, 1, 64, 0, 0)
+phdr = struct.pack("<IIQQQQQQ", 1, 5, 0, BASE, BASE, size, size, 0x1000)
+
+with open("tiny", "wb") as f:
+    f.write(ehdr + phdr + CODE)
@@ -0,0 +1,26 @@
+# builds a minimal ARM ELF executable with an ARM entry calling a Thumb function, there is no cross toolchain on our
+# build hosts
+import struct
+
+BASE = 0x10000
+CODE = struct.pack("<2I",
+    0xfa000000,  # _start: blx f         (ARM)
+    0xe7f000f0,  #         udf #0
+) + struct.pack("<5H",
+    0x2800,      # f:      cmp r0, #0    (Thumb)
+    0xbf08,      #         it eq
+    0x4770,      #         bxeq lr
+    0x3001,      #         adds r0, #1
+    0x4770,      #         bx lr
+)
+
+EHDR_SIZE, PHDR_SIZE = 52, 32
+entry = BASE + EHDR_SIZE + PHDR_SIZE
+size = EHDR_SIZE + PHDR_SIZE + len(CODE)
+
+ident = b"\x7fELF" + bytes([1, 1, 1, 0]) + bytes(8)
+ehdr = ident + struct.pack("<HHIIIIIHHHHHH", 2, 40, 1, entry, EHDR_SIZE, 0, 0x5000000, EHDR_SIZE, PHDR_SIZE, 1, 40, 0, 0)
+phdr = struct.pack("<IIIIIIII", 1, 0, BASE, BASE, size, size, 5, 0x1000)
+
+with open("tiny", "wb") as f:
+    f.write(ehdr + phdr + CODE)
@@ -0,0 +1,18 @@
+#include <stdio.h>
+
+static inline int square(int x) {
+  return x * x;
+}
+
+__attribute__((noinline)) int sum_of_squares(int a, int b) {
+  return square(a) + square(b);
+}
+
+__attribute__((noinline)) int scale(int a) {
+  return a * 3 + 1;
+}
+
+int main(int argc, char **argv) {
+  printf("%d\n", sum_of_squares(argc, scale(argc)));
+  return 0;
+}
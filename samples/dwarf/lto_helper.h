@@ -0,0 +1,4 @@
+//declared in a header, so the abstract origin of the inlined copy refers to the file table of lto_square.c
+
+
+static inline int helper(int x) { return x + 1; }
@@ -0,0 +1,2 @@
+int square(int x);
+int main(int argc, char **argv) { return square(argc); }
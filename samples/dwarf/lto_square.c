@@ -0,0 +1,2 @@
+#include "lto_helper.h"
+int square(int x) { return helper(x) * x; }
@@ -0,0 +1,15 @@
+#!/bin/sh
+# inline is stripped of all symbols, its debug information is shipped separately in inline.debug
+gcc -O2 -g -o inline inline.c
+objcopy --only-keep-debug inline inline.debug
+strip -s inline
+# without section headers .eh_frame can only be found through PT_GNU_EH_FRAME
+python3 -c '
+import struct
+d = bytearray(open("inline", "rb").read())
+d[0x28:0x30] = struct.pack("<Q", 0)      # e_shoff
+d[0x3c:0x40] = struct.pack("<HH", 0, 0)  # e_shnum, e_shstrndx
+open("inline_nosections", "wb").write(d)
+'
+# link time optimization refers to the abstract origins in other compilation units with DW_FORM_ref_addr
+gcc -O2 -g -flto -o lto lto_main.c lto_square.c
@@ -0,0 +1,28 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int add(int a, int b) { return a + b; }
+static int sub(int a, int b) { return a - b; }
+
+int (*operations[])(int, int) = { add, sub };
+
+int apply(int op, int a, int b) {
+  return operations[op](a, b);
+}
+
+char *duplicate(const char *str) {
+  size_t len = strlen(str);
+  char *res = malloc(len + 1);
+  memcpy(res, str, len + 1);
+  return res;
+}
+
+int main(int argc, char *argv[]) {
+  char *copy = duplicate(argv[0]);
+  printf("%s %d\n", copy, apply(argc & 1, argc, 2));
+  free(copy);
+  if (argc > 3)
+    exit(1);
+  return 0;
+}
@@ -0,0 +1,3 @@
+gcc -O1 -g -fPIE -pie -o pie libcalls.c
+gcc -O1 -g -fPIC -shared -o libcalls.so libcalls.c
+gcc -O1 -fPIE -pie -o noreturn noreturn.c
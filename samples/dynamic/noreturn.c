@@ -0,0 +1,28 @@
+#include <stdio.h>
+#include <stdlib.h>
+
+__attribute__((noinline)) static void fail(const char *msg) {
+  puts(msg);
+  exit(2);
+}
+
+__attribute__((noinline)) static void trap(void) {
+  __builtin_trap();
+}
+
+__attribute__((noinline)) int checked_div(int a, int b) {
+  if (b == 0)
+    fail("division by zero");
+  return a / b;
+}
+
+__attribute__((noinline)) int checked_mod(int a, int b) {
+  if (b == 0)
+    trap();
+  return a % b;
+}
+
+int main(int argc, char *argv[]) {
+  printf("%d\n", checked_div(argc, argc - 1) + checked_mod(argc, argc - 2));
+  return 0;
+}
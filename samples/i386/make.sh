@@ -0,0 +1,3 @@
+#!/bin/sh
+gcc -m32 -O1 -fno-stack-protector -fno-asynchronous-unwind-tables -nostdlib -static-pie -o tiny tiny.c
+gcc -m32 -O1 -fPIC -shared -nostdlib -fno-asynchronous-unwind-tables -o shared shared.c
@@ -0,0 +1,13 @@
+//imports a function through the PLT and data through the GOT, table needs R_386_RELATIVE and R_386_32
+extern int imported(int);
+extern int imported_data;
+
+static int local(int x) {
+	return x + 1;
+}
+
+int (*table[])(int) = {local, imported};
+
+int f(int x) {
+	return imported(x) + imported_data;
+}
@@ -0,0 +1,11 @@
+//stdcall functions pop their arguments with ret imm16
+__attribute__((stdcall, noinline)) int f(int x) {
+	return x * 3;
+}
+
+volatile int arg;
+
+void _start(void) {
+	arg = f(arg);
+	__builtin_trap();
+}
@@ -0,0 +1,78 @@
+# builds minimal x86-64 Mach-O executables by hand, there is no Mach-O linker on our build hosts
+import struct
+
+CODE = bytes.fromhex(
+    "488d4101c3"        # add_one: lea rax,[rcx+1]; ret
+    "e8f6ffffff4883c001c3"  # add_two: call add_one; add rax,1; ret
+    "b929000000e8ecffffffc3"  # start: mov ecx,41; call add_two; ret
+)
+DATA = struct.pack("<Q", 0x1122334455667788)
+
+def name16(s):
+    return s.encode().ljust(16, b"\0")
+
+def uleb(v):
+    out = b""
+    while True:
+        b = v & 0x7f
+        v >>= 7
+        if v:
+            out += bytes([b | 0x80])
+        else:
+            return out + bytes([b])
+
+def section(sect, seg, addr, size, offset, flags):
+    return struct.pack("<16s16sQQIIIIIIII", name16(sect), name16(seg), addr, size, offset, 4, 0, 0, flags, 0, 0, 0)
+
+def segment(name, vmaddr, vmsize, fileoff, filesize, prot, sections):
+    return struct.pack("<II16sQQQQiiII", 0x19, 72 + 80 * len(sections), name16(name), vmaddr, vmsize,
+                       fileoff, filesize, prot, prot, len(sections), 0) + b"".join(sections)
+
+def build(cputype, cpusubtype, base):
+    text = base + 0x800
+    data = base + 0x1000
+    symbols = [("_add_one", 0x0f, 1, text), ("_start", 0x0f, 1, text + 15), ("_counter", 0x0f, 2, data)]
+    strtab = b"\0"
+    nlist = b""
+    for name, typ, sect, value in symbols:
+        nlist += struct.pack("<IBBHQ", len(strtab), typ, sect, 0, value)
+        strtab += name.encode() + b"\0"
+    # add_two is deliberately only known from the function starts
+    starts = uleb(0x800) + uleb(5) + uleb(10) + b"\0"
+    linkedit = nlist + strtab
+    starts_off = 0x2000 + len(linkedit)
+    linkedit += starts
+
+    cmds = [
+        segment("__PAGEZERO", 0, base, 0, 0, 0, []),
+        segment("__TEXT", base, 0x1000, 0, 0x1000, 5,
+                [section("__text", "__TEXT", text, len(CODE), 0x800, 0x80000400)]),
+        segment("__DATA", data, 0x2000, 0x1000, 0x1000, 3,
+                [section("__data", "__DATA", data, len(DATA), 0x1000, 0)]),
+        segment("__LINKEDIT", base + 0x3000, 0x1000, 0x2000, len(linkedit), 1, []),
+        struct.pack("<IIIIII", 0x2, 24, 0x2000, len(symbols), 0x2000 + len(nlist), len(strtab)),
+        struct.pack("<IIII", 0x26, 16, starts_off, len(starts)),
+        struct.pack("<IIQQ", 0x80000028, 24, 0x80f, 0),  # LC_MAIN, entry is start
+    ]
+    cmds = b"".join(cmds)
+    header = struct.pack("<IiiIIIII", 0xfeedfacf, cputype, cpusubtype, 2, 7, len(cmds), 0, 0)
+    image = bytearray(0x2000 + len(linkedit))
+    image[0:len(header) + len(cmds)] = header + cmds
+    image[0x800:0x800 + len(CODE)] = CODE
+    image[0x1000:0x1000 + len(DATA)] = DATA
+    image[0x2000:] = linkedit
+    return bytes(image)
+
+CPU_X86_64 = 0x01000007
+CPU_ARM64 = 0x0100000c
+
+thin = build(CPU_X86_64, 3, 0x100000000)
+open("tiny", "wb").write(thin)
+
+# universal binary, the arm64 slice only differs in its cpu type and load address
+other = build(CPU_ARM64, 0, 0x200000000)
+fat = struct.pack(">II", 0xcafebabe, 2)
+fat += struct.pack(">iiIII", CPU_ARM64, 0, 0x4000, len(other), 14)
+fat += struct.pack(">iiIII", CPU_X86_64, 3, 0x8000, len(thin), 14)
+fat = fat.ljust(0x4000, b"\0") + other.ljust(0x4000, b"\0") + thin
+open("tiny_fat", "wb").write(fat)
@@ -0,0 +1,50 @@
+# builds a stripped big endian MIPS32 ELF executable, the only function besides the entry is known from its .eh_frame
+# entry. There is no cross toolchain on our build hosts
+import struct
+
+BASE = 0x400000
+EHDR_SIZE, PHDR_SIZE, SHDR_SIZE = 52, 32, 40
+start = BASE + EHDR_SIZE + PHDR_SIZE
+f = start + 12
+
+CODE = struct.pack(">8I",
+    0x0c000000 | (f >> 2) & 0x3ffffff,  # _start: jal f
+    0x00000000,                         #         nop
+    0x0000000d,                         #         break
+    0x10800002,                         # f:      beqz $a0, out
+    0x00001021,                         #         move $v0, $zero
+    0x24820001,                         #         addiu $v0, $a0, 1
+    0x03e00008,                         # out:    jr $ra
+    0x00000000,                         #         nop
+)
+f_size = start + len(CODE) - f
+
+eh_frame_addr = start + len(CODE)
+cie = struct.pack(">I", 0) + b"\x01zR\x00" + bytes([4, 0x7c, 31, 1, 0x1b, 0x0c, 29, 0])  # def_cfa $sp, 0
+fde_start = 4 + len(cie)
+fde = struct.pack(">Iii", fde_start + 4, f - (eh_frame_addr + fde_start + 8), f_size) + bytes(4)  # aug. length, nops
+EH_FRAME = struct.pack(">I", len(cie)) + cie + struct.pack(">I", len(fde)) + fde + bytes(4)
+
+SHSTRTAB = b"\x00.text\x00.eh_frame\x00.shstrtab\x00"
+text_off = EHDR_SIZE + PHDR_SIZE
+eh_off = text_off + len(CODE)
+str_off = eh_off + len(EH_FRAME)
+sh_off = (str_off + len(SHSTRTAB) + 3) & ~3
+size = eh_off + len(EH_FRAME)
+
+def shdr(name, typ, flags, addr, off, size, align):
+    return struct.pack(">10I", name, typ, flags, addr, off, size, 0, 0, align, 0)
+
+sections = (shdr(0, 0, 0, 0, 0, 0, 0) +
+    shdr(1, 1, 6, start, text_off, len(CODE), 4) +
+    shdr(7, 1, 2, eh_frame_addr, eh_off, len(EH_FRAME), 4) +
+    shdr(17, 3, 0, 0, str_off, len(SHSTRTAB), 1))
+
+ident = b"\x7fELF" + bytes([1, 2, 1, 0]) + bytes(8)
+ehdr = ident + struct.pack(">HHIIIIIHHHHHH", 2, 8, 1, start, EHDR_SIZE, sh_off, 0x1000, EHDR_SIZE, PHDR_SIZE, 1,
+    SHDR_SIZE, 4, 3)
+phdr = struct.pack(">8I", 1, 0, BASE, BASE, size, size, 5, 0x1000)
+
+with open("tiny", "wb") as out:
+    data = ehdr + phdr + CODE + EH_FRAME + SHSTRTAB
+    out.write(data + bytes(sh_off - len(data)) + sections)
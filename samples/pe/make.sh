@@ -0,0 +1,5 @@
+as --64 -o tiny.o tiny.s
+objcopy -O pe-x86-64 tiny.o tiny_coff.o
+ld -m i386pep --dll --export-all-symbols -e start --image-base 0x10000000 -o tiny.dll tiny_coff.o
+ld -m i386pep --dll --export-all-symbols -e start --image-base 0x10000000 -s -o tiny_stripped.dll tiny_coff.o
+rm tiny.o tiny_coff.o
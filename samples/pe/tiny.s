@@ -0,0 +1,28 @@
+	.text
+	.globl	add_one
+add_one:
+	lea	1(%rcx), %rax
+	ret
+	.globl	add_two
+add_two:
+	call	add_one
+	add	$1, %rax
+	ret
+	.globl	start
+start:
+	mov	$41, %ecx
+	call	add_two
+	ret
+	.data
+	.globl	counter
+counter:
+	.quad	0x1122334455667788
+	.bss
+buffer:
+	.zero	0x2000
+	.section .pdata,"a"
+	.long	0x1000, 0x1005, 0x4000
+	.long	0x1005, 0x100f, 0x4000
+	.long	0x100f, 0x101a, 0x4000
+	.section .xdata,"a"
+	.long	0x00000001
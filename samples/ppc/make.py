@@ -0,0 +1,24 @@
+# builds a minimal big endian PowerPC ELF executable, there is no cross toolchain on our build hosts
+import struct
+
+BASE = 0x10000000
+EHDR_SIZE, PHDR_SIZE = 52, 32
+start = BASE + EHDR_SIZE + PHDR_SIZE
+f = start + 8
+
+CODE = struct.pack(">6I",
+    0x48000001 | (f - start),  # _start: bl f
+    0x7fe00008,                #         trap
+    0x2c030000,                # f:      cmpwi r3, 0
+    0x4d820020,                #         beqlr
+    0x38630001,                #         addi r3, r3, 1
+    0x4e800020,                #         blr
+)
+size = EHDR_SIZE + PHDR_SIZE + len(CODE)
+
+ident = b"\x7fELF" + bytes([1, 2, 1, 0]) + bytes(8)
+ehdr = ident + struct.pack(">HHIIIIIHHHHHH", 2, 20, 1, start, EHDR_SIZE, 0, 0, EHDR_SIZE, PHDR_SIZE, 1, 40, 0, 0)
+phdr = struct.pack(">8I", 1, 0, BASE, BASE, size, size, 5, 0x10000)
+
+with open("tiny", "wb") as out:
+    out.write(ehdr + phdr + CODE)
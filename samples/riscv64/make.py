@@ -0,0 +1,26 @@
+# builds a minimal RV64GC ELF executable with compressed instructions, there is no cross toolchain on our build hosts
+import struct
+
+BASE = 0x10000
+EHDR_SIZE, PHDR_SIZE = 64, 56
+start = BASE + EHDR_SIZE + PHDR_SIZE
+f = start + 10
+
+CODE = struct.pack("<IIH",
+    0x00000097,                         # _start: auipc ra, 0
+    0x000080e7 | (f - start) << 20,     #         jalr ra, f(ra)
+    0x9002,                             #         c.ebreak
+) + struct.pack("<3H",
+    0xc111,                             # f:      c.beqz a0, 1f
+    0x0505,                             #         c.addi a0, 1
+    0x8082,                             # 1:      c.jr ra
+)
+size = EHDR_SIZE + PHDR_SIZE + len(CODE)
+
+ident = b"\x7fELF" + bytes([2, 1, 1, 0]) + bytes(8)
+# EF_RISCV_RVC | EF_RISCV_FLOAT_ABI_DOUBLE
+ehdr = ident + struct.pack("<HHIQQQIHHHHHH", 2, 243, 1, start, EHDR_SIZE, 0, 5, EHDR_SIZE, PHDR_SIZE, 1, 64, 0, 0)
+phdr = struct.pack("<IIQQQQQQ", 1, 5, 0, BASE, BASE, size, size, 0x1000)
+
+with open("tiny", "wb") as out:
+    out.write(ehdr + phdr + CODE)